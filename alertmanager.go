@@ -0,0 +1,357 @@
+package slackgw
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+// AlertmanagerPayload is the payload sent by Prometheus Alertmanager's
+// webhook receiver. See https://prometheus.io/docs/alerting/configuration/#webhook_config
+type AlertmanagerPayload struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []Alert           `json:"alerts"`
+}
+
+// Alert is a single alert in an AlertmanagerPayload
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// AlertRoute sends alerts whose labels match all of Match to Channel
+type AlertRoute struct {
+	Match   map[string]string `json:"match"`
+	Channel string            `json:"channel"`
+}
+
+// AlertGroup is the data the alert template is executed with. Alerts
+// going to the same channel are grouped by their status
+type AlertGroup struct {
+	Status            string
+	Alerts            []Alert
+	Receiver          string
+	GroupLabels       map[string]string
+	CommonLabels      map[string]string
+	CommonAnnotations map[string]string
+	ExternalURL       string
+}
+
+// Alert statuses used by Alertmanager
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// DefaultAlertTemplate defines the "title" and "text" templates used to
+// render a group of alerts into an attachment
+const DefaultAlertTemplate = `{{define "title"}}[{{toUpper .Status}}{{if eq .Status "firing"}}:{{len .Alerts}}{{end}}]{{range $k, $v := .GroupLabels}} {{$v}}{{end}}{{end}}
+{{define "text"}}{{range .Alerts}}• *{{or .Annotations.summary .Labels.alertname}}*{{with .Annotations.description}} {{.}}{{end}}
+{{end}}{{end}}`
+
+// functions available to alert and hook templates
+var templateFuncs = template.FuncMap{
+	"toUpper": strings.ToUpper,
+	"toLower": strings.ToLower,
+	"join":    strings.Join,
+}
+
+// NewAlertTemplate returns the default alert template
+func NewAlertTemplate() *template.Template {
+	return template.Must(template.New("alert").Funcs(templateFuncs).Parse(DefaultAlertTemplate))
+}
+
+// ParseAlertTemplate parses the template in filename on top of the default
+// alert template, so that it may override "title", "text", or both
+func ParseAlertTemplate(filename string) (*template.Template, error) {
+	t, err := NewAlertTemplate().ParseFiles(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse alert template")
+	}
+	return t, nil
+}
+
+// ReadAlertRoutes reads a JSON list of AlertRoutes from filename
+func ReadAlertRoutes(filename string) ([]AlertRoute, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open alert routes")
+	}
+	defer f.Close()
+
+	var routes []AlertRoute
+	if err := json.NewDecoder(f).Decode(&routes); err != nil {
+		return nil, errors.Wrap(err, "failed to decode alert routes")
+	}
+	for i, route := range routes {
+		if route.Channel == "" {
+			return nil, errors.Errorf("alert route #%d does not specify a channel", i+1)
+		}
+	}
+	return routes, nil
+}
+
+// alertChannel returns the channel alert should be sent to: the channel
+// of the first matching route, or AlertChannel
+func (s *Server) alertChannel(alert *Alert) string {
+	s.config.RLock()
+	defer s.config.RUnlock()
+
+	for _, route := range s.AlertRoutes {
+		matched := true
+		for k, v := range route.Match {
+			if alert.Labels[k] != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return route.Channel
+		}
+	}
+	return s.AlertChannel
+}
+
+// renderAlerts renders a group of alerts into an attachment
+func (s *Server) renderAlerts(g *AlertGroup) (slack.Attachment, error) {
+	s.config.RLock()
+	t := s.AlertTemplate
+	s.config.RUnlock()
+	if t == nil {
+		t = NewAlertTemplate()
+	}
+
+	var title, text bytes.Buffer
+	if err := t.ExecuteTemplate(&title, "title", g); err != nil {
+		return slack.Attachment{}, errors.Wrap(err, "failed to render alert title")
+	}
+	if err := t.ExecuteTemplate(&text, "text", g); err != nil {
+		return slack.Attachment{}, errors.Wrap(err, "failed to render alert text")
+	}
+
+	color := "danger"
+	if g.Status == AlertResolved {
+		color = "good"
+	}
+
+	return slack.Attachment{
+		Color:      color,
+		Fallback:   title.String(),
+		Title:      title.String(),
+		TitleLink:  g.ExternalURL,
+		Text:       strings.TrimSpace(text.String()),
+		MarkdownIn: []string{"text"},
+	}, nil
+}
+
+// alertMessages groups the alerts in payload by channel, and renders a
+// message for each channel with one attachment per status
+func (s *Server) alertMessages(payload *AlertmanagerPayload) ([]*Message, error) {
+	var channels []string
+	groups := make(map[string]map[string]*AlertGroup)
+	for _, alert := range payload.Alerts {
+		channel := s.alertChannel(&alert)
+		if channel == "" {
+			return nil, errors.Errorf("no channel for alert %s", alert.Labels["alertname"])
+		}
+
+		byStatus, ok := groups[channel]
+		if !ok {
+			byStatus = make(map[string]*AlertGroup)
+			groups[channel] = byStatus
+			channels = append(channels, channel)
+		}
+
+		status := AlertFiring
+		if alert.Status == AlertResolved {
+			status = AlertResolved
+		}
+		g, ok := byStatus[status]
+		if !ok {
+			g = &AlertGroup{
+				Status:            status,
+				Receiver:          payload.Receiver,
+				GroupLabels:       payload.GroupLabels,
+				CommonLabels:      payload.CommonLabels,
+				CommonAnnotations: payload.CommonAnnotations,
+				ExternalURL:       payload.ExternalURL,
+			}
+			byStatus[status] = g
+		}
+		g.Alerts = append(g.Alerts, alert)
+	}
+
+	msgs := make([]*Message, 0, len(channels))
+	for _, channel := range channels {
+		msg := msgPool.Get().(*Message)
+		msg.Channel = channel
+		msgs = append(msgs, msg)
+		for _, status := range []string{AlertFiring, AlertResolved} {
+			g, ok := groups[channel][status]
+			if !ok {
+				continue
+			}
+			a, err := s.renderAlerts(g)
+			if err != nil {
+				for _, msg := range msgs {
+					releaseMessage(msg)
+				}
+				return nil, err
+			}
+			msg.Params.Attachments = append(msg.Params.Attachments, a)
+		}
+	}
+	return msgs, nil
+}
+
+// httpAlertmanager accepts Alertmanager webhook payloads. As Alertmanager
+// cannot send custom headers, the key may also be given as the last part
+// of the URL: /alertmanager/<key>
+func (s *Server) httpAlertmanager(w http.ResponseWriter, r *http.Request) {
+	if pdebug.Enabled {
+		pdebug.Printf("http: alertmanager request...")
+		defer pdebug.Printf("done with alertmanager request")
+	}
+
+	key := r.Header.Get(s.AuthHeader)
+	if key == "" && strings.HasPrefix(r.URL.Path, "/alertmanager/") {
+		key = strings.Trim(strings.TrimPrefix(r.URL.Path, "/alertmanager/"), "/")
+	}
+
+	cred, err := s.authenticateKey(r, key, "/alertmanager")
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to authenticate: %s", err)
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if strings.ToLower(r.Method) != "post" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload AlertmanagerPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to decode payload: %s", err)
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	msgs, err := s.alertMessages(&payload)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to render alerts: %s", err)
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		for _, msg := range msgs {
+			releaseMessage(msg)
+		}
+	}()
+
+	for _, msg := range msgs {
+		if cred != nil {
+			if !s.allowsChannel(cred, msg.Channel) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			msg.owner = cred.Name
+		}
+	}
+
+	// Alertmanager retries the whole notification if any channel fails.
+	// Carry on with the other channels, and remember which ones went
+	// through, so that the retry only posts to the ones that failed
+	keys := make([]string, len(msgs))
+	errs := make([]error, len(msgs))
+	var failed []string
+	for i, msg := range msgs {
+		keys[i] = alertIdempotencyKey(&payload, msg)
+		if errs[i] = s.postAlertOnce(keys[i], msg); errs[i] != nil {
+			if pdebug.Enabled {
+				pdebug.Printf("failed to post alerts to '%s': %s", msg.Channel, errs[i])
+			}
+			failed = append(failed, msg.Channel+": "+errs[i].Error())
+		}
+	}
+
+	for i, key := range keys {
+		status := http.StatusOK
+		if errs[i] != nil || len(failed) == 0 {
+			// Forget the key: there will be no retry to deduplicate
+			status = http.StatusInternalServerError
+		}
+		s.idempotency.resolve(key, status, nil, nil, s.idempotencyWindow())
+	}
+
+	if len(failed) > 0 {
+		http.Error(w, "Failed to post alerts to "+strings.Join(failed, ", "), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Sent"))
+}
+
+// alertIdempotencyKey identifies the message for one channel of an
+// Alertmanager notification. Retries of a notification render the same
+// messages, and therefore have the same keys
+func alertIdempotencyKey(payload *AlertmanagerPayload, msg *Message) string {
+	h := sha256.New()
+	for _, v := range []string{payload.GroupKey, payload.Status, msg.Channel, msg.Message} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	json.NewEncoder(h).Encode(msg.Params.Attachments)
+	return idempotencyKey(msg.owner, "/alertmanager", hex.EncodeToString(h.Sum(nil)))
+}
+
+// postAlertOnce posts msg, unless a previous attempt at the same
+// notification already got it through. The key must be resolved by
+// the caller afterwards
+func (s *Server) postAlertOnce(key string, msg *Message) error {
+	for {
+		e, ok := s.idempotency.claim(key, "")
+		if ok {
+			break
+		}
+
+		<-e.ready
+		if e.status != 0 {
+			if pdebug.Enabled {
+				pdebug.Printf("alerts to '%s' were already posted", msg.Channel)
+			}
+			return nil
+		}
+	}
+	return s.postMessage(msg)
+}
@@ -0,0 +1,129 @@
+package slackgw
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAlertmanager(t *testing.T) {
+	s0 := New()
+	s0.AlertChannel = "#alerts"
+	s0.AlertRoutes = []AlertRoute{
+		{Match: map[string]string{"team": "db"}, Channel: "#db"},
+	}
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	received := make(chan *Message, 2)
+	go func() {
+		for msg := range s0.bus {
+			c := *msg
+			received <- &c
+			msg.dst <- nil
+		}
+	}()
+
+	payload := `{
+  "version": "4",
+  "status": "firing",
+  "receiver": "slackgw",
+  "groupLabels": {"alertname": "HighLatency"},
+  "externalURL": "http://alertmanager:9093",
+  "alerts": [
+    {"status": "firing", "labels": {"alertname": "HighLatency", "team": "db"}, "annotations": {"summary": "db latency is high"}},
+    {"status": "resolved", "labels": {"alertname": "HighLatency", "team": "db"}, "annotations": {"summary": "replica latency is back to normal"}},
+    {"status": "firing", "labels": {"alertname": "HighLatency", "team": "web"}, "annotations": {"summary": "web latency is high"}}
+  ]
+}`
+	res, err := http.Post(s.URL+"/alertmanager", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+
+	msg := <-received
+	if msg.Channel != "#db" {
+		t.Fatalf("expected first message to go to #db, got %s", msg.Channel)
+	}
+	if len(msg.Params.Attachments) != 2 {
+		t.Fatalf("expected firing and resolved attachments, got %#v", msg.Params.Attachments)
+	}
+	firing, resolved := msg.Params.Attachments[0], msg.Params.Attachments[1]
+	if firing.Color != "danger" || firing.Title != "[FIRING:1] HighLatency" || !strings.Contains(firing.Text, "db latency is high") {
+		t.Errorf("unexpected firing attachment: %#v", firing)
+	}
+	if resolved.Color != "good" || resolved.Title != "[RESOLVED] HighLatency" || !strings.Contains(resolved.Text, "back to normal") {
+		t.Errorf("unexpected resolved attachment: %#v", resolved)
+	}
+
+	msg = <-received
+	if msg.Channel != "#alerts" || len(msg.Params.Attachments) != 1 {
+		t.Errorf("expected unrouted alert to go to #alerts: %#v", msg)
+	}
+}
+
+func TestAlertmanagerPartialFailure(t *testing.T) {
+	s0 := New()
+	s0.AlertChannel = "#alerts"
+	s0.AlertRoutes = []AlertRoute{
+		{Match: map[string]string{"team": "db"}, Channel: "#db"},
+	}
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	// The first message to #db fails
+	var posted []string
+	failed := false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range s0.bus {
+			if msg.Channel == "#db" && !failed {
+				failed = true
+				msg.dst <- errors.New("internal_error")
+				continue
+			}
+			posted = append(posted, msg.Channel)
+			msg.dst <- nil
+		}
+	}()
+
+	payload := `{
+  "groupKey": "{}:{alertname=\"HighLatency\"}",
+  "status": "firing",
+  "alerts": [
+    {"status": "firing", "labels": {"alertname": "HighLatency", "team": "db"}, "annotations": {"summary": "db latency is high"}},
+    {"status": "firing", "labels": {"alertname": "HighLatency", "team": "web"}, "annotations": {"summary": "web latency is high"}}
+  ]
+}`
+	post := func() (int, string) {
+		res, err := http.Post(s.URL+"/alertmanager", "application/json", strings.NewReader(payload))
+		if err != nil {
+			t.Fatalf("failed to post: %s", err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	if status, body := post(); status != http.StatusInternalServerError || !strings.Contains(body, "#db") {
+		t.Errorf("expected 500 mentioning #db, got %d %s", status, body)
+	}
+	// Alertmanager retries the whole notification
+	if status, body := post(); status != http.StatusOK {
+		t.Errorf("expected 200 on retry, got %d %s", status, body)
+	}
+
+	close(s0.bus)
+	<-done
+	if len(posted) != 2 || posted[0] != "#alerts" || posted[1] != "#db" {
+		t.Errorf("expected each channel to be posted to once, got %v", posted)
+	}
+}
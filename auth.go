@@ -0,0 +1,266 @@
+package slackgw
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+)
+
+// Credential describes a single API key, and what the client holding
+// it is allowed to do.
+type Credential struct {
+	Name      string   `json:"name"`      // Human readable name of the client
+	Key       string   `json:"key"`       // The secret sent in the auth header
+	Secret    string   `json:"secret"`    // Shared secret used to sign requests
+	Channels  []string `json:"channels"`  // Channels this client may post to. Empty means any
+	Endpoints []string `json:"endpoints"` // Endpoints this client may access. Empty means any
+	Enabled   bool     `json:"enabled"`
+	// If true, the client must sign its requests. Requests carrying
+	// only the key in the auth header are rejected
+	RequireSignature bool `json:"require_signature"`
+}
+
+// CredentialStore looks up credentials by their key, or by their name
+// (for signed requests, where the key is never sent over the wire)
+type CredentialStore interface {
+	Lookup(key string) (*Credential, error)
+	LookupName(name string) (*Credential, error)
+}
+
+var errCredentialNotFound = errors.New("credential not found")
+
+func matchScope(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, x := range list {
+		if x == "*" || x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsChannel returns true if the credential may post to channel ch
+func (c *Credential) AllowsChannel(ch string) bool {
+	return matchScope(c.Channels, ch)
+}
+
+// how long the name of a channel looked up by allowsChannel is trusted
+const channelNameTTL = 5 * time.Minute
+
+// allowsChannel checks if c may post to ch. Credentials list channels by
+// name, while updates and deletes refer to them by ID, so IDs are looked
+// up and checked by name as well. Names are cached for channelNameTTL
+func (s *Server) allowsChannel(c *Credential, ch string) bool {
+	if c.AllowsChannel(ch) {
+		return true
+	}
+	if !isChannelID(ch) {
+		return false
+	}
+	if name, ok := s.channelIDs.freshName(ch, channelNameTTL); ok && strings.HasPrefix(name, "#") {
+		return c.AllowsChannel(name)
+	}
+	if s.slack == nil {
+		return false
+	}
+
+	info, err := s.slack.GetConversationInfo(ch, false)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to look up channel %s: %s", ch, err)
+		}
+		return false
+	}
+	name := "#" + info.Name
+	s.channelIDs.set(name, ch)
+	return c.AllowsChannel(name)
+}
+
+// AllowsEndpoint returns true if the credential may access the endpoint
+// specified by path. An endpoint also covers the paths below it, i.e.
+// "/jobs" allows "/jobs/1234". "/" only allows "/" itself: use "*" to
+// allow every endpoint
+func (c *Credential) AllowsEndpoint(path string) bool {
+	if len(c.Endpoints) == 0 {
+		return true
+	}
+	for _, x := range c.Endpoints {
+		if x == "*" || x == path {
+			return true
+		}
+		if x = strings.TrimSuffix(x, "/"); x != "" && strings.HasPrefix(path, x+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// FileCredentialStore is a CredentialStore backed by a JSON file containing
+// a list of credentials:
+//
+//	[
+//	  { "name": "deploy", "key": "...", "channels": ["#deploy"], "endpoints": ["/post"], "enabled": true },
+//	  ...
+//	]
+type FileCredentialStore struct {
+	path  string
+	mutex sync.RWMutex
+	creds map[string]*Credential
+	names map[string]*Credential
+}
+
+// NewFileCredentialStore creates a new FileCredentialStore, and loads the
+// credentials from the file
+func NewFileCredentialStore(path string) (*FileCredentialStore, error) {
+	s := &FileCredentialStore{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload re-reads the credentials from the file. Use this to
+// add or revoke keys without restarting the server
+func (s *FileCredentialStore) Reload() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open credentials file")
+	}
+	defer f.Close()
+
+	var list []*Credential
+	if err := json.NewDecoder(f).Decode(&list); err != nil {
+		return errors.Wrap(err, "failed to decode credentials file")
+	}
+
+	creds := make(map[string]*Credential)
+	names := make(map[string]*Credential)
+	for _, c := range list {
+		if c.Key == "" && c.Secret == "" {
+			return errors.Errorf("credential '%s' has neither a key nor a secret", c.Name)
+		}
+		if c.RequireSignature && c.Secret == "" {
+			return errors.Errorf("credential '%s' requires signatures, but has no secret", c.Name)
+		}
+		if _, ok := names[c.Name]; ok {
+			return errors.Errorf("duplicate credential name '%s'", c.Name)
+		}
+		names[c.Name] = c
+
+		if c.Key == "" {
+			continue
+		}
+		if _, ok := creds[c.Key]; ok {
+			return errors.Errorf("credential '%s' has a duplicate key", c.Name)
+		}
+		creds[c.Key] = c
+	}
+
+	s.mutex.Lock()
+	s.creds = creds
+	s.names = names
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *FileCredentialStore) Lookup(key string) (*Credential, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	c, ok := s.creds[key]
+	if !ok {
+		return nil, errCredentialNotFound
+	}
+	return c, nil
+}
+
+func (s *FileCredentialStore) LookupName(name string) (*Credential, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	c, ok := s.names[name]
+	if !ok {
+		return nil, errCredentialNotFound
+	}
+	return c, nil
+}
+
+// authenticate resolves the identity of the client sending the request.
+// If the server does not require authentication, returns a nil credential
+// and no error.
+func (s *Server) authenticate(r *http.Request) (*Credential, error) {
+	if s.AuthHeader == "" {
+		return nil, nil
+	}
+	return s.authenticateKey(r, r.Header.Get(s.AuthHeader), r.URL.Path)
+}
+
+// authenticateKey is like authenticate, but the key and the name of the
+// endpoint used to check the credential's scope are given explicitly,
+// for endpoints that cannot receive the key via the auth header
+func (s *Server) authenticateKey(r *http.Request, key, endpoint string) (*Credential, error) {
+	if s.AuthHeader == "" {
+		return nil, nil
+	}
+
+	if r.Header.Get(SignatureHeader) == "" {
+		return s.authenticateToken(key, endpoint)
+	}
+
+	if s.Credentials == nil {
+		return nil, errors.New("signed requests require a credential store")
+	}
+	c, err := s.verifySignature(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid signature")
+	}
+	return checkCredential(c, endpoint)
+}
+
+// authenticateToken authenticates a plain API key, for clients that do
+// not sign their requests (or cannot, such as gRPC clients)
+func (s *Server) authenticateToken(key, endpoint string) (*Credential, error) {
+	if s.AuthHeader == "" {
+		return nil, nil
+	}
+
+	if key == "" {
+		return nil, errors.New("missing credentials")
+	}
+
+	if s.Credentials == nil {
+		if !s.Authorized(key) {
+			return nil, errors.New("invalid credentials")
+		}
+		return nil, nil
+	}
+
+	c, err := s.Credentials.Lookup(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid credentials")
+	}
+	if c.RequireSignature {
+		return nil, errors.Errorf("credential '%s' must sign its requests", c.Name)
+	}
+	return checkCredential(c, endpoint)
+}
+
+// checkCredential makes sure that c may be used to access endpoint
+func checkCredential(c *Credential, endpoint string) (*Credential, error) {
+	if !c.Enabled {
+		return nil, errors.Errorf("credential '%s' is disabled", c.Name)
+	}
+
+	if !c.AllowsEndpoint(endpoint) {
+		return nil, errors.Errorf("credential '%s' may not access %s", c.Name, endpoint)
+	}
+	return c, nil
+}
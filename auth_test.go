@@ -0,0 +1,195 @@
+package slackgw
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testCredentials = `[
+  { "name": "deploy", "key": "deploy-key", "channels": ["#deploy"], "enabled": true },
+  { "name": "jobs", "key": "jobs-key", "endpoints": ["/jobs"], "enabled": true },
+  { "name": "stream", "key": "stream-key", "endpoints": ["/events/stream"], "enabled": true },
+  { "name": "revoked", "key": "revoked-key", "enabled": false }
+]`
+
+func newTestCredentialStore(t *testing.T) *FileCredentialStore {
+	f, err := ioutil.TempFile("", "slackgw-creds")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	f.WriteString(testCredentials)
+	f.Sync()
+
+	store, err := NewFileCredentialStore(f.Name())
+	if err != nil {
+		t.Fatalf("failed to load credentials: %s", err)
+	}
+	return store
+}
+
+func TestFileCredentialStore(t *testing.T) {
+	store := newTestCredentialStore(t)
+
+	c, err := store.Lookup("deploy-key")
+	if err != nil {
+		t.Fatalf("failed to lookup credential: %s", err)
+	}
+	if c.Name != "deploy" {
+		t.Errorf("expected 'deploy', got '%s'", c.Name)
+	}
+	if !c.AllowsChannel("#deploy") || c.AllowsChannel("#general") {
+		t.Errorf("channel scope not applied")
+	}
+	if !c.AllowsEndpoint("/post") {
+		t.Errorf("empty endpoint list should allow any endpoint")
+	}
+
+	c.Endpoints = []string{"/", "/jobs/"}
+	for path, allowed := range map[string]bool{"/": true, "/post": false, "/jobs": false, "/jobs/1234": true, "/jobsx": false} {
+		if c.AllowsEndpoint(path) != allowed {
+			t.Errorf("expected AllowsEndpoint(%s) to be %t", path, allowed)
+		}
+	}
+
+	if _, err := store.Lookup("unknown-key"); err == nil {
+		t.Errorf("lookup of unknown key should fail")
+	}
+}
+
+func TestPostMessageScopes(t *testing.T) {
+	s0 := New()
+	s0.AuthHeader = "X-Slackgw-Auth"
+	s0.Credentials = newTestCredentialStore(t)
+	s0.slack = &mockSlackClient{names: map[string]string{"C024BE91L": "deploy", "C024BE91M": "general"}}
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	go func() {
+		for msg := range s0.bus {
+			msg.dst <- nil
+		}
+	}()
+
+	tests := []struct {
+		key     string
+		channel string
+		status  int
+	}{
+		{"deploy-key", "#deploy", http.StatusOK},
+		{"deploy-key", "#general", http.StatusForbidden},
+		{"deploy-key", "C024BE91L", http.StatusOK},
+		{"deploy-key", "C024BE91M", http.StatusForbidden},
+		{"jobs-key", "#deploy", http.StatusForbidden},
+		{"revoked-key", "#deploy", http.StatusForbidden},
+		{"", "#deploy", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		v := url.Values{"channel": {test.channel}, "message": {"Hello, World!"}}
+		req, _ := http.NewRequest("POST", s.URL+"/post", strings.NewReader(v.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Slackgw-Auth", test.key)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("request failed: %s", err)
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.status {
+			t.Errorf("key '%s' posting to '%s': expected %d, got %d", test.key, test.channel, test.status, res.StatusCode)
+		}
+	}
+}
+
+func TestAllowsChannelCache(t *testing.T) {
+	s := New()
+	defer s.Close()
+	client := &mockSlackClient{names: map[string]string{"C024BE91L": "deploy"}}
+	s.slack = client
+
+	c := &Credential{Channels: []string{"#deploy"}}
+	for i := 0; i < 3; i++ {
+		if !s.allowsChannel(c, "C024BE91L") {
+			t.Errorf("expected C024BE91L to be allowed")
+		}
+	}
+	if n := atomic.LoadInt32(&client.lookups); n != 1 {
+		t.Errorf("expected the channel to be looked up once, got %d", n)
+	}
+}
+
+func TestSignedRequests(t *testing.T) {
+	f, err := ioutil.TempFile("", "slackgw-creds")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`[{ "name": "signer", "secret": "shh", "require_signature": true, "enabled": true }]`)
+	f.Close()
+
+	store, err := NewFileCredentialStore(f.Name())
+	if err != nil {
+		t.Fatalf("failed to load credentials: %s", err)
+	}
+
+	s0 := New()
+	s0.AuthHeader = "X-Slackgw-Auth"
+	s0.Credentials = store
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	go func() {
+		for msg := range s0.bus {
+			msg.dst <- nil
+		}
+	}()
+
+	body := url.Values{"channel": {"#test"}, "message": {"Hello, World!"}}.Encode()
+	newRequest := func(secret string, signedAt time.Time) *http.Request {
+		req, _ := http.NewRequest("POST", s.URL+"/post", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		SignRequest(req, "signer", secret, []byte(body), signedAt)
+		return req
+	}
+
+	now := time.Now()
+	valid := newRequest("shh", now)
+	replayed := newRequest("shh", now)
+	tampered := newRequest("shh", now.Add(time.Second))
+	tampered.URL.RawQuery = "async=1"
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"valid", valid, http.StatusOK},
+		{"replayed", replayed, http.StatusForbidden},
+		{"tampered query", tampered, http.StatusForbidden},
+		{"bad secret", newRequest("wrong", now), http.StatusForbidden},
+		{"stale", newRequest("shh", now.Add(-time.Hour)), http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		res, err := http.DefaultClient.Do(test.req)
+		if err != nil {
+			t.Errorf("%s: request failed: %s", test.name, err)
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.status {
+			t.Errorf("%s: expected %d, got %d", test.name, test.status, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package slackgw
+
+import (
+	"sync"
+	"time"
+)
+
+// channelQueue holds the messages waiting to be sent to a single channel.
+// It grows as needed, so that a channel that is being paced or retried
+// never holds up the dispatch of messages to other channels
+type channelQueue struct {
+	mutex sync.Mutex
+	msgs  []*Message
+	ready chan struct{} // signaled when messages are added, closed when the queue is retired
+}
+
+func newChannelQueue() *channelQueue {
+	return &channelQueue{ready: make(chan struct{}, 1)}
+}
+
+// push adds msg to the queue. It never blocks
+func (q *channelQueue) push(msg *Message) {
+	q.mutex.Lock()
+	q.msgs = append(q.msgs, msg)
+	q.mutex.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes the oldest message from the queue
+func (q *channelQueue) pop() (*Message, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.msgs) == 0 {
+		return nil, false
+	}
+	msg := q.msgs[0]
+	q.msgs[0] = nil
+	q.msgs = q.msgs[1:]
+	return msg, true
+}
+
+func (q *channelQueue) empty() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.msgs) == 0
+}
+
+// channelIDCache remembers the IDs of the channels that messages were
+// addressed to by name (e.g. #general), as reported by slack when they
+// were sent or when the channels were looked up
+type channelIDCache struct {
+	mutex sync.RWMutex
+	ids   map[string]string      // name -> ID
+	names map[string]channelName // ID -> name
+}
+
+// channelName is the name a channel was last seen with
+type channelName struct {
+	name    string
+	updated time.Time
+}
+
+// lookup returns the ID of ch, or ch itself if it is not known
+func (c *channelIDCache) lookup(ch string) string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if id, ok := c.ids[ch]; ok {
+		return id
+	}
+	return ch
+}
+
+// name returns the name the channel with ID id was last addressed by
+func (c *channelIDCache) name(id string) (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	n, ok := c.names[id]
+	return n.name, ok
+}
+
+// freshName is like name, but only returns names that were seen within
+// ttl, since channels may be renamed
+func (c *channelIDCache) freshName(id string, ttl time.Duration) (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	n, ok := c.names[id]
+	if !ok || time.Since(n.updated) > ttl {
+		return "", false
+	}
+	return n.name, true
+}
+
+func (c *channelIDCache) set(ch, id string) {
+	if id == "" || id == ch {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ids == nil {
+		c.ids = make(map[string]string)
+		c.names = make(map[string]channelName)
+	}
+	c.ids[ch] = id
+	c.names[id] = channelName{name: ch, updated: time.Now()}
+}
@@ -0,0 +1,45 @@
+package slackgw
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChannelQueue(t *testing.T) {
+	q := newChannelQueue()
+
+	// Way more than a channel would hold, without anybody popping
+	for i := 0; i < 1000; i++ {
+		q.push(&Message{Timestamp: string(rune('a' + i%26))})
+	}
+	for i := 0; i < 1000; i++ {
+		msg, ok := q.pop()
+		if !ok {
+			t.Fatalf("queue is empty after %d messages", i)
+		}
+		if expected := string(rune('a' + i%26)); msg.Timestamp != expected {
+			t.Fatalf("message %d: expected '%s', got '%s'", i, expected, msg.Timestamp)
+		}
+	}
+	if !q.empty() {
+		t.Errorf("expected queue to be empty")
+	}
+
+	var c channelIDCache
+	c.set("#general", "C024BE91L")
+	c.set("C12345", "C12345")
+	for ch, expected := range map[string]string{"#general": "C024BE91L", "#random": "#random", "C12345": "C12345"} {
+		if id := c.lookup(ch); id != expected {
+			t.Errorf("expected '%s' for '%s', got '%s'", expected, ch, id)
+		}
+	}
+	if name, ok := c.name("C024BE91L"); !ok || name != "#general" {
+		t.Errorf("expected '#general' for C024BE91L, got '%s'", name)
+	}
+	if name, ok := c.freshName("C024BE91L", time.Minute); !ok || name != "#general" {
+		t.Errorf("expected fresh '#general' for C024BE91L, got '%s'", name)
+	}
+	if _, ok := c.freshName("C024BE91L", -time.Second); ok {
+		t.Errorf("expected name of C024BE91L to be stale")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Flags that are re-read from the config file on SIGHUP. Everything else
+// (listeners, tokens, RTM handlers, ...) requires a restart
+var reloadableFlags = []string{
+	"webhook.channel",
+	"alertmanager.channel",
+	"alertmanager.template",
+	"alertmanager.routes",
+	"repo-channels",
+	"hooks",
+	"smtp.channels",
+}
+
+// config maps flag names to their values, as read from a YAML file. The
+// keys are the names of the command line flags, and nested maps are
+// joined with '.', so that
+//
+//	gpubsub-forward:
+//	  topic: slackgw-forward
+//	  event: [MessageEvent, ReactionAddedEvent]
+//
+// is the same as -gpubsub-forward.topic=slackgw-forward
+// -gpubsub-forward.event=MessageEvent -gpubsub-forward.event=ReactionAddedEvent
+type config map[string][]string
+
+func readConfig(filename string) (config, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[interface{}]interface{}
+	if err := yaml.Unmarshal(buf, &m); err != nil {
+		return nil, err
+	}
+
+	c := config{}
+	if err := c.flatten("", m); err != nil {
+		return nil, err
+	}
+	for name := range c {
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown setting '%s'", name)
+		}
+	}
+	return c, nil
+}
+
+func (c config) flatten(prefix string, m map[interface{}]interface{}) error {
+	for k, v := range m {
+		name := prefix + fmt.Sprint(k)
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			if err := c.flatten(name+".", v); err != nil {
+				return err
+			}
+		case []interface{}:
+			for _, x := range v {
+				switch x.(type) {
+				case map[interface{}]interface{}, []interface{}:
+					return fmt.Errorf("invalid value for '%s'", name)
+				}
+				c[name] = append(c[name], fmt.Sprint(x))
+			}
+		case nil:
+			c[name] = []string{""}
+		default:
+			c[name] = []string{fmt.Sprint(v)}
+		}
+	}
+	return nil
+}
+
+// listValue is a flag.Value that appends to a list on each Set
+type listValue interface {
+	flag.Value
+	Reset()
+}
+
+// apply sets the flags listed in names (or all flags in c, if names is
+// nil), except those given on the command line. Flags in names that are
+// missing from c are reset to their defaults
+func (c config) apply(names []string, cmdline map[string]bool) error {
+	if names == nil {
+		for name := range c {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		if cmdline[name] {
+			continue
+		}
+		f := flag.Lookup(name)
+		values, ok := c[name]
+		if l, isList := f.Value.(listValue); isList {
+			// Start over, rather than appending to the previous values.
+			// Lists default to being empty
+			l.Reset()
+		} else if !ok {
+			values = []string{f.DefValue}
+		}
+		for _, v := range values {
+			if err := flag.Set(name, v); err != nil {
+				return fmt.Errorf("invalid value for '%s': %s", name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var (
+	testChannel = flag.String("cfgtest.channel", "#default", "")
+	testTopic   = flag.String("cfgtest.forward.topic", "", "")
+	testEvents  eventList
+)
+
+func init() {
+	flag.Var(&testEvents, "cfgtest.forward.event", "")
+}
+
+func writeTestConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "slackgw-config")
+	if err != nil {
+		t.Fatalf("failed to create config file: %s", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("failed to write config file: %s", err)
+	}
+	return f.Name()
+}
+
+func TestConfig(t *testing.T) {
+	filename := writeTestConfig(t, `
+cfgtest:
+  channel: "#ops"
+  forward:
+    topic: slackgw-forward
+    event: [MessageEvent, ReactionAddedEvent]
+`)
+	defer os.Remove(filename)
+
+	c, err := readConfig(filename)
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err)
+	}
+	expected := config{
+		"cfgtest.channel":       {"#ops"},
+		"cfgtest.forward.topic": {"slackgw-forward"},
+		"cfgtest.forward.event": {"MessageEvent", "ReactionAddedEvent"},
+	}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected %v, got %v", expected, c)
+	}
+
+	// Flags given on the command line take precedence
+	*testTopic = "from-cmdline"
+	cmdline := map[string]bool{"cfgtest.forward.topic": true}
+	if err := c.apply(nil, cmdline); err != nil {
+		t.Fatalf("failed to apply config: %s", err)
+	}
+	if *testChannel != "#ops" || *testTopic != "from-cmdline" || testEvents.String() != "MessageEvent,ReactionAddedEvent" {
+		t.Errorf("unexpected settings: %s %s %s", *testChannel, *testTopic, testEvents)
+	}
+
+	// On reload, settings that were removed go back to their defaults,
+	// and lists are replaced rather than appended to
+	filename2 := writeTestConfig(t, `
+cfgtest:
+  forward:
+    event: [MessageEvent]
+`)
+	defer os.Remove(filename2)
+
+	c, err = readConfig(filename2)
+	if err != nil {
+		t.Fatalf("failed to read config: %s", err)
+	}
+	if err := c.apply([]string{"cfgtest.channel", "cfgtest.forward.topic", "cfgtest.forward.event"}, cmdline); err != nil {
+		t.Fatalf("failed to apply config: %s", err)
+	}
+	if *testChannel != "#default" || *testTopic != "from-cmdline" || testEvents.String() != "MessageEvent" {
+		t.Errorf("unexpected settings after reload: %s %s %s", *testChannel, *testTopic, testEvents)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for _, content := range []string{
+		"cfgtest:\n  bogus: 1\n",                   // unknown setting
+		"cfgtest:\n  forward:\n    event: [[a]]\n", // nested list
+		"cfgtest: [\n",                             // not YAML
+	} {
+		filename := writeTestConfig(t, content)
+		if _, err := readConfig(filename); err == nil {
+			t.Errorf("expected an error for %q", content)
+		}
+		os.Remove(filename)
+	}
+
+	c := config{"cfgtest.forward.event": {"NoSuchEvent"}}
+	if err := c.apply(nil, nil); err == nil {
+		t.Errorf("expected an error for an invalid value")
+	}
+}
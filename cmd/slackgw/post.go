@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultURL = "http://127.0.0.1:4979"
+
+// _post implements `slackgw post`, which sends a message through a
+// running gateway
+func _post(args []string) int {
+	var gwurl string
+	var authheader string
+	var authtokenf string
+	var channel string
+	var message string
+	var file string
+	var filetype string
+	var threadts string
+	var broadcast bool
+	var key string
+	var async bool
+	var jsonout bool
+
+	fs := flag.NewFlagSet("post", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: slackgw post -c CHANNEL [-m MESSAGE] [-f FILE] [options]\n\n")
+		fmt.Fprintf(os.Stderr, "If neither -m nor -f is given (or -m is '-'), the message is read from stdin.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&gwurl, "url", "", "URL of the gateway (default $SLACKGW_URL or "+defaultURL+")")
+	fs.StringVar(&authheader, "authheader", "X-Slackgw-Auth", "header used to send the auth token")
+	fs.StringVar(&authtokenf, "authtokenfile", "", "File containing the auth token (default $SLACKGW_AUTH)")
+	fs.StringVar(&channel, "c", "", "channel to post to")
+	fs.StringVar(&channel, "channel", "", "channel to post to")
+	fs.StringVar(&message, "m", "", "message to post")
+	fs.StringVar(&message, "message", "", "message to post")
+	fs.StringVar(&file, "f", "", "file to upload, with the message as its comment")
+	fs.StringVar(&file, "file", "", "file to upload, with the message as its comment")
+	fs.StringVar(&filetype, "filetype", "", "slack file type of the uploaded file (e.g. 'text', 'diff')")
+	fs.StringVar(&threadts, "t", "", "timestamp of the message to reply to")
+	fs.StringVar(&threadts, "thread", "", "timestamp of the message to reply to")
+	fs.BoolVar(&broadcast, "broadcast", false, "also show the reply in the channel")
+	fs.StringVar(&key, "key", "", "key to later update or delete the message with")
+	fs.BoolVar(&async, "async", false, "return as soon as the gateway has queued the message")
+	fs.BoolVar(&jsonout, "json", false, "print the gateway's response as JSON")
+	fs.Parse(args)
+
+	if channel == "" {
+		fmt.Fprintf(os.Stderr, "You must provide a channel via -c\n")
+		return 1
+	}
+
+	if message == "-" || (message == "" && file == "") {
+		buf, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read from stdin: %s\n", err)
+			return 1
+		}
+		message = strings.TrimRight(string(buf), "\n")
+	}
+
+	if gwurl == "" {
+		gwurl = os.Getenv("SLACKGW_URL")
+	}
+	if gwurl == "" {
+		gwurl = defaultURL
+	}
+
+	token := os.Getenv("SLACKGW_AUTH")
+	if authtokenf != "" {
+		buf, err := ioutil.ReadFile(authtokenf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read from '%s': %s\n", authtokenf, err)
+			return 1
+		}
+		token = strings.TrimSpace(string(buf))
+	}
+
+	v := url.Values{"channel": {channel}, "message": {message}}
+	if threadts != "" {
+		v.Set("thread_ts", threadts)
+	}
+	if broadcast {
+		v.Set("reply_broadcast", "true")
+	}
+	if key != "" {
+		v.Set("key", key)
+	}
+	if filetype != "" {
+		v.Set("filetype", filetype)
+	}
+
+	var body io.Reader
+	var ct string
+	if file == "" {
+		body = strings.NewReader(v.Encode())
+		ct = "application/x-www-form-urlencoded"
+	} else {
+		buf, w, err := multipartBody(v, file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read from '%s': %s\n", file, err)
+			return 1
+		}
+		body = buf
+		ct = w.FormDataContentType()
+	}
+
+	u := strings.TrimSuffix(gwurl, "/") + "/post"
+	if async {
+		u += "?async=1"
+	}
+	req, err := http.NewRequest("POST", u, body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create request: %s\n", err)
+		return 1
+	}
+	req.Header.Set("Content-Type", ct)
+	if token != "" {
+		req.Header.Set(authheader, token)
+	}
+	if jsonout {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to post message: %s\n", err)
+		return 1
+	}
+	defer res.Body.Close()
+
+	out, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read response: %s\n", err)
+		return 1
+	}
+	if res.StatusCode/100 != 2 {
+		fmt.Fprintf(os.Stderr, "Failed to post message: %s: %s\n", res.Status, strings.TrimSpace(string(out)))
+		return 1
+	}
+
+	os.Stdout.Write(out)
+	if len(out) > 0 && out[len(out)-1] != '\n' {
+		fmt.Println()
+	}
+	return 0
+}
+
+// multipartBody encodes v and the contents of filename as a
+// multipart/form-data request body
+func multipartBody(v url.Values, filename string) (*bytes.Buffer, *multipart.Writer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for k, vs := range v {
+		for _, s := range vs {
+			w.WriteField(k, s)
+		}
+	}
+	fw, err := w.CreateFormFile("file", filepath.Base(filename))
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.Copy(fw, f); err != nil {
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, nil, err
+	}
+	return buf, w, nil
+}
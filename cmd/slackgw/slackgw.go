@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
+	"text/template"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -38,15 +41,68 @@ func (l *eventList) Set(v string) error {
 	return nil
 }
 
+func (l *eventList) Reset() {
+	*l = nil
+}
+
+type stringList []string
+
+func (l stringList) String() string {
+	return strings.Join(l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func (l *stringList) Reset() {
+	*l = nil
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "post":
+			os.Exit(_post(os.Args[2:]))
+		}
+	}
 	os.Exit(_main())
 }
 
 func _main() int {
+	var configf string
 	var listen string
 	var token string
 	var tokenf string
 	var authtokenf string
+	var authkeysf string
+	var msgkeysf string
+	var outboxdir string
+	var retry slackgw.RetryPolicy
+	var chinterval time.Duration
+	var idemwindow time.Duration
+	var webhookch string
+	var alertch string
+	var alerttmplf string
+	var alertroutesf string
+	var githubsecretf string
+	var gitlabtokenf string
+	var repochannelsf string
+	var hooksf string
+	var syslogudp string
+	var syslogtcp string
+	var syslogrulesf string
+	var sysloginterval time.Duration
+	var syslogburst int
+	var smtplisten string
+	var smtpdomain string
+	var smtpchannelsf string
+	var smtpmaxsize int64
+	var grpclisten string
+	var streamevents bool
+	var streambuffer int
+	var streamdrop string
 	var projectID string
 	var topic string
 	var name string
@@ -54,21 +110,92 @@ func _main() int {
 	var selfaddress bool
 	var server bool
 	var events eventList
+	var fwdurls stringList
+	var fwdsecretf string
+	var fwdevents eventList
+	var fwdselfaddress bool
+	var fwddeadletter string
+	var fwdbatchsize int
+	var fwdflush time.Duration
 
+	flag.StringVar(&configf, "config", "", "YAML file containing the settings. Flags given on the command line take precedence")
 	flag.StringVar(&listen, "listen", "127.0.0.1:4979", "listen address for HTTP interface")
 	flag.StringVar(&token, "token", "", "Slack bot token")
 	flag.StringVar(&tokenf, "tokenfile", "", "Slack bot token file")
 	flag.StringVar(&authtokenf, "authtokenfile", "", "File containing token used to authentication when posting")
+	flag.StringVar(&authkeysf, "authkeysfile", "", "JSON file containing per-client API keys and their scopes")
+	flag.StringVar(&outboxdir, "outboxdir", "", "Directory to persist outgoing messages in until they are sent")
+	flag.IntVar(&retry.MaxAttempts, "retry.max-attempts", slackgw.DefaultRetryPolicy.MaxAttempts, "maximum number of attempts to send a message")
+	flag.DurationVar(&retry.InitialBackoff, "retry.initial-backoff", slackgw.DefaultRetryPolicy.InitialBackoff, "wait before the first retry")
+	flag.DurationVar(&retry.MaxBackoff, "retry.max-backoff", slackgw.DefaultRetryPolicy.MaxBackoff, "maximum wait between retries")
+	flag.Float64Var(&retry.Jitter, "retry.jitter", slackgw.DefaultRetryPolicy.Jitter, "randomize waits between retries by up to this fraction")
+	flag.DurationVar(&chinterval, "channel-interval", slackgw.DefaultChannelInterval, "minimum interval between messages to the same channel")
+	flag.DurationVar(&idemwindow, "idempotency-window", slackgw.DefaultIdempotencyWindow, "how long Idempotency-Key headers are remembered")
+	flag.StringVar(&webhookch, "webhook.channel", "", "default channel for incoming webhook payloads")
+	flag.StringVar(&alertch, "alertmanager.channel", "", "default channel for alerts posted to /alertmanager")
+	flag.StringVar(&alerttmplf, "alertmanager.template", "", "template file overriding the 'title' and/or 'text' templates for alerts")
+	flag.StringVar(&alertroutesf, "alertmanager.routes", "", "JSON file containing label based routes for alerts")
+	flag.StringVar(&githubsecretf, "github.secretfile", "", "File containing the secret used to verify GitHub webhooks")
+	flag.StringVar(&gitlabtokenf, "gitlab.tokenfile", "", "File containing the secret token used to verify GitLab webhooks")
+	flag.StringVar(&repochannelsf, "repo-channels", "", "JSON file mapping repositories to channels for GitHub/GitLab events")
+	flag.StringVar(&hooksf, "hooks", "", "JSON file containing the routes for /hook/{name}")
+	flag.StringVar(&syslogudp, "syslog.udp", "", "listen address for syslog over UDP (e.g. ':514')")
+	flag.StringVar(&syslogtcp, "syslog.tcp", "", "listen address for syslog over TCP (e.g. ':514')")
+	flag.StringVar(&syslogrulesf, "syslog.rules", "", "JSON file containing rules routing syslog messages to channels")
+	flag.DurationVar(&sysloginterval, "syslog.interval", slackgw.DefaultSyslogInterval, "interval for collapsing and rate limiting syslog messages")
+	flag.IntVar(&syslogburst, "syslog.burst", slackgw.DefaultSyslogBurst, "maximum number of syslog lines sent to a channel per interval")
+	flag.StringVar(&smtplisten, "smtp.listen", "", "listen address for the SMTP server (e.g. ':25')")
+	flag.StringVar(&smtpdomain, "smtp.domain", slackgw.DefaultSMTPDomain, "domain to accept mail for")
+	flag.StringVar(&smtpchannelsf, "smtp.channels", "", "JSON file mapping local parts of addresses to channels")
+	flag.Int64Var(&smtpmaxsize, "smtp.max-size", slackgw.DefaultSMTPMaxSize, "maximum size of mail messages")
+	flag.StringVar(&grpclisten, "grpc.listen", "", "listen address for the gRPC interface (e.g. ':4980')")
+	flag.BoolVar(&streamevents, "events.stream", false, "serve RTM events on /events/stream (connects to RTM even without -rtm)")
+	flag.IntVar(&streambuffer, "events.buffer", slackgw.DefaultStreamBuffer, "number of events buffered for each streaming client")
+	flag.StringVar(&streamdrop, "events.drop", slackgw.DropNewest, "what to do when a streaming client's buffer is full ('newest', 'oldest' or 'disconnect')")
+	flag.StringVar(&msgkeysf, "msgkeysfile", "", "File to persist message keys used by /update, /delete and upserts")
 	flag.StringVar(&projectID, "gpubsub-forward.project_id", "", "Google Cloud Project ID")
 	flag.StringVar(&topic, "gpubsub-forward.topic", "slackgw-forward", "topic to forward to")
 	flag.Var(&events, "gpubsub-forward.event", "event(s) to forward")
 	flag.BoolVar(&selfaddress, "gpubsub-forward.self-addressed-only", true, "forward only if it's address to this bot")
+	flag.Var(&fwdurls, "webhook-forward.url", "URL(s) to forward events to")
+	flag.StringVar(&fwdsecretf, "webhook-forward.secretfile", "", "File containing the secret used to sign forwarded events")
+	flag.Var(&fwdevents, "webhook-forward.event", "event(s) to forward")
+	flag.BoolVar(&fwdselfaddress, "webhook-forward.self-addressed-only", true, "forward only if it's address to this bot")
+	flag.StringVar(&fwddeadletter, "webhook-forward.deadletter", "", "Directory to write events that could not be forwarded to")
+	flag.IntVar(&fwdbatchsize, "webhook-forward.batch-size", slackgw.DefaultForwardBatchSize, "maximum number of events per request")
+	flag.DurationVar(&fwdflush, "webhook-forward.flush-interval", slackgw.DefaultForwardFlushInterval, "maximum time events are held before being forwarded")
 	flag.StringVar(&name, "name", "slackgw", "bot name")
-	flag.StringVar(&rtm, "rtm", "", "RTM handler to enable (e.g. 'gpubsub-forward')")
+	flag.StringVar(&rtm, "rtm", "", "comma separated list of RTM handlers to enable ('gpubsub-forward', 'webhook-forward')")
 	flag.BoolVar(&server, "server", true, "Turn on/off HTTP server")
 	flag.Parse()
 
+	cmdline := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+	if configf != "" {
+		cfg, err := readConfig(configf)
+		if err != nil {
+			fmt.Printf("Failed to read config from '%s': %s\n", configf, err)
+			return 1
+		}
+		if err := cfg.apply(nil, cmdline); err != nil {
+			fmt.Printf("Failed to apply config from '%s': %s\n", configf, err)
+			return 1
+		}
+	}
+
 	s := slackgw.New()
+	s.Retry = retry
+	s.ChannelInterval = chinterval
+	s.IdempotencyWindow = idemwindow
+	s.StreamBuffer = streambuffer
+
+	switch streamdrop {
+	case slackgw.DropNewest, slackgw.DropOldest, slackgw.DropDisconnect:
+		s.StreamDropPolicy = streamdrop
+	default:
+		fmt.Printf("Unknown drop policy '%s'\n", streamdrop)
+		return 1
+	}
 
 	if token == "" {
 		if tokenf == "" {
@@ -88,22 +215,135 @@ func _main() int {
 		return 1
 	}
 
+	if outboxdir != "" {
+		outbox, err := slackgw.OpenOutbox(outboxdir)
+		if err != nil {
+			fmt.Printf("Failed to open outbox in '%s': %s\n", outboxdir, err)
+			return 1
+		}
+		s.Outbox = outbox
+	}
+
 	// Start slack client
 	if err := s.StartSlack(token); err != nil {
 		fmt.Printf("Failed to start slack client: %s\n", err)
 		return 1
 	}
 
+	// Authentication, shared by the HTTP and gRPC interfaces
+	if authtokenf != "" {
+		buf, err := ioutil.ReadFile(authtokenf)
+		if err != nil {
+			fmt.Printf("Failed to read from '%s'", authtokenf)
+			return 1
+		}
+		// Like `slackgw post`, ignore the trailing newline most files have
+		s.AuthToken = strings.TrimSpace(string(buf))
+		s.AuthHeader = "X-Slackgw-Auth"
+	}
+
+	var credstore *slackgw.FileCredentialStore
+	if authkeysf != "" {
+		store, err := slackgw.NewFileCredentialStore(authkeysf)
+		if err != nil {
+			fmt.Printf("Failed to load API keys from '%s': %s\n", authkeysf, err)
+			return 1
+		}
+		credstore = store
+		s.Credentials = store
+		s.AuthHeader = "X-Slackgw-Auth"
+	}
+
+	// Settings that are reloaded on SIGHUP
+	configure := func() error {
+		var alerttmpl *template.Template
+		if alerttmplf != "" {
+			t, err := slackgw.ParseAlertTemplate(alerttmplf)
+			if err != nil {
+				return fmt.Errorf("failed to load alert template from '%s': %s", alerttmplf, err)
+			}
+			alerttmpl = t
+		}
+
+		var alertroutes []slackgw.AlertRoute
+		if alertroutesf != "" {
+			routes, err := slackgw.ReadAlertRoutes(alertroutesf)
+			if err != nil {
+				return fmt.Errorf("failed to load alert routes from '%s': %s", alertroutesf, err)
+			}
+			alertroutes = routes
+		}
+
+		var repochannels map[string]string
+		if repochannelsf != "" {
+			channels, err := slackgw.ReadRepoChannels(repochannelsf)
+			if err != nil {
+				return fmt.Errorf("failed to load repository channels from '%s': %s", repochannelsf, err)
+			}
+			repochannels = channels
+		}
+
+		var hooks map[string]*slackgw.Hook
+		if hooksf != "" {
+			h, err := slackgw.ReadHooks(hooksf)
+			if err != nil {
+				return fmt.Errorf("failed to load hooks from '%s': %s", hooksf, err)
+			}
+			hooks = h
+		}
+
+		var smtpchannels map[string]string
+		if smtpchannelsf != "" {
+			channels, err := slackgw.ReadSMTPChannels(smtpchannelsf)
+			if err != nil {
+				return fmt.Errorf("failed to load SMTP channels from '%s': %s", smtpchannelsf, err)
+			}
+			smtpchannels = channels
+		}
+
+		s.Reconfigure(func(s *slackgw.Server) {
+			s.WebhookChannel = webhookch
+			s.AlertChannel = alertch
+			s.AlertTemplate = alerttmpl
+			s.AlertRoutes = alertroutes
+			s.RepoChannels = repochannels
+			s.Hooks = hooks
+			s.SMTPChannels = smtpchannels
+		})
+		return nil
+	}
+	if err := configure(); err != nil {
+		fmt.Printf("Failed to configure: %s\n", err)
+		return 1
+	}
+
 	// Start HTTP Interface
 	if server {
-		if authtokenf != "" {
-			buf, err := ioutil.ReadFile(authtokenf)
+		if msgkeysf != "" {
+			store, err := slackgw.NewFileMessageKeyStore(msgkeysf)
 			if err != nil {
-				fmt.Printf("Failed to read from '%s'", authtokenf)
+				fmt.Printf("Failed to load message keys from '%s': %s\n", msgkeysf, err)
 				return 1
 			}
-			s.AuthToken = string(buf)
-			s.AuthHeader = "X-Slackgw-Auth"
+			s.MessageKeys = store
+		}
+
+		if githubsecretf != "" {
+			buf, err := ioutil.ReadFile(githubsecretf)
+			if err != nil {
+				fmt.Printf("Failed to read from '%s': %s\n", githubsecretf, err)
+				return 1
+			}
+			s.GitHubSecret = strings.TrimSpace(string(buf))
+		}
+
+		if gitlabtokenf != "" {
+			buf, err := ioutil.ReadFile(gitlabtokenf)
+			if err != nil {
+				fmt.Printf("Failed to read from '%s': %s\n", gitlabtokenf, err)
+				return 1
+			}
+			s.GitLabToken = strings.TrimSpace(string(buf))
 		}
 
 		proto := "tcp" // hardcode for now
@@ -113,19 +353,144 @@ func _main() int {
 		}
 	}
 
-	// Enable RTM handler
-	switch rtm {
-	case "gpubsub-forward":
-		hctx := context.Background()
-		cl, err := pubsub.NewClient(hctx, projectID)
+	// Start syslog listeners
+	if syslogudp != "" || syslogtcp != "" {
+		if syslogrulesf == "" {
+			fmt.Printf("You must provide syslog rules via -syslog.rules\n")
+			return 1
+		}
+		rules, err := slackgw.ReadSyslogRules(syslogrulesf)
 		if err != nil {
-			fmt.Printf("Failed to create pubsub client: %s", err)
+			fmt.Printf("Failed to load syslog rules from '%s': %s\n", syslogrulesf, err)
+			return 1
+		}
+		s.SyslogRules = rules
+		s.SyslogInterval = sysloginterval
+		s.SyslogBurst = syslogburst
+
+		for proto, listen := range map[string]string{"udp": syslogudp, "tcp": syslogtcp} {
+			if listen == "" {
+				continue
+			}
+			if err := s.StartSyslog(proto, listen); err != nil {
+				fmt.Printf("Failed to start syslog server on %s:%s: %s\n", proto, listen, err)
+				return 1
+			}
+		}
+	}
+
+	// Start SMTP server
+	if smtplisten != "" {
+		if smtpchannelsf == "" {
+			fmt.Printf("You must provide the channels to accept mail for via -smtp.channels\n")
 			return 1
 		}
+		s.SMTPDomain = smtpdomain
+		s.SMTPMaxSize = smtpmaxsize
+		if err := s.StartSMTP(smtplisten); err != nil {
+			fmt.Printf("Failed to start SMTP server on %s: %s\n", smtplisten, err)
+			return 1
+		}
+	}
 
-		fwd := gcp.NewPubsubForwarder(cl, topic, []int64(events)...)
-		fwd.SelfAddressedOnly = selfaddress
-		s.StartRTM(fwd)
+	// Start gRPC interface
+	if grpclisten != "" {
+		if err := s.StartGRPC(grpclisten); err != nil {
+			fmt.Printf("Failed to start gRPC server on %s: %s\n", grpclisten, err)
+			return 1
+		}
+	}
+
+	// Enable RTM handlers
+	var handlers []slackgw.SlackRTMHandler
+	for _, name := range strings.Split(rtm, ",") {
+		var h slackgw.SlackRTMHandler
+		switch strings.TrimSpace(name) {
+		case "":
+			continue
+		case "gpubsub-forward":
+			hctx := context.Background()
+			cl, err := pubsub.NewClient(hctx, projectID)
+			if err != nil {
+				fmt.Printf("Failed to create pubsub client: %s", err)
+				return 1
+			}
+
+			fwd := gcp.NewPubsubForwarder(cl, topic, []int64(events)...)
+			fwd.SelfAddressedOnly = selfaddress
+			h = fwd
+		case "webhook-forward":
+			if len(fwdurls) == 0 {
+				fmt.Printf("You must provide URL(s) to forward to via -webhook-forward.url\n")
+				return 1
+			}
+			var secret string
+			if fwdsecretf != "" {
+				buf, err := ioutil.ReadFile(fwdsecretf)
+				if err != nil {
+					fmt.Printf("Failed to read from '%s': %s\n", fwdsecretf, err)
+					return 1
+				}
+				secret = strings.TrimSpace(string(buf))
+			}
+
+			fwd := slackgw.NewWebhookForwarder([]string(fwdurls), secret, []int64(fwdevents)...)
+			fwd.SelfAddressedOnly = fwdselfaddress
+			fwd.DeadLetterDir = fwddeadletter
+			fwd.BatchSize = fwdbatchsize
+			fwd.FlushInterval = fwdflush
+			fwd.Retry = retry
+			h = fwd
+		default:
+			fmt.Printf("Unknown RTM handler '%s'\n", name)
+			return 1
+		}
+		handlers = append(handlers, slackgw.ChainRTM(h, slackgw.RecoverRTM()))
+	}
+
+	// Streaming clients need RTM events, even without a handler
+	var rtmhandler slackgw.SlackRTMHandler
+	startrtm := grpclisten != "" || streamevents
+	if len(handlers) > 0 {
+		// Each handler sees every event, even if another one fails
+		mux := slackgw.NewRTMMux(handlers...)
+		mux.ErrorHandler = func(h slackgw.SlackRTMHandler, ctx *slackgw.RTMCtx, err error) {
+			fmt.Printf("Failed to handle %s event: %s\n", ctx.Event.Type, err)
+		}
+		rtmhandler = mux
+		startrtm = true
+	}
+	if startrtm {
+		if err := s.StartRTM(rtmhandler); err != nil {
+			fmt.Printf("Failed to start RTM client: %s\n", err)
+			return 1
+		}
+	}
+
+	// Re-read the config file and the files it refers to on SIGHUP
+	s.Reload = func() error {
+		if configf != "" {
+			cfg, err := readConfig(configf)
+			if err != nil {
+				fmt.Printf("Failed to read config from '%s': %s\n", configf, err)
+				return err
+			}
+			if err := cfg.apply(reloadableFlags, cmdline); err != nil {
+				fmt.Printf("Failed to apply config from '%s': %s\n", configf, err)
+				return err
+			}
+		}
+		if credstore != nil {
+			if err := credstore.Reload(); err != nil {
+				fmt.Printf("Failed to reload API keys from '%s': %s\n", authkeysf, err)
+				return err
+			}
+		}
+		if err := configure(); err != nil {
+			fmt.Printf("Failed to reload: %s\n", err)
+			return err
+		}
+		return nil
 	}
 
 	// Wait till we're killed, or something goes wrong
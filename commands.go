@@ -0,0 +1,362 @@
+package slackgw
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+// CommandHandler runs a command. If it returns an error, the error is
+// sent back to the user
+type CommandHandler func(*Command) error
+
+// Command is a command sent to the bot, with its arguments parsed
+// according to the usage it was registered with
+type Command struct {
+	Name    string              // e.g. "deploy"
+	Args    map[string]string   // positional arguments and flags, by name
+	Ctx     *RTMCtx             // the event the command came with
+	Message *slack.MessageEvent // the message the command came with
+
+	router *CommandRouter
+}
+
+// Arg returns the named argument, or an empty string if it was not given
+// and has no default
+func (c *Command) Arg(name string) string {
+	return c.Args[name]
+}
+
+// Reply posts text to the channel the command came from. Commands sent in
+// a thread are replied to in that thread
+func (c *Command) Reply(text string) error {
+	msg := msgPool.Get().(*Message)
+	defer releaseMessage(msg)
+
+	msg.Channel = c.Message.Channel
+	msg.ThreadTimestamp = c.Message.ThreadTimestamp
+	msg.Message = text
+	return c.router.server.postMessage(msg)
+}
+
+// commandArg is a positional argument or a flag of a command
+type commandArg struct {
+	name     string
+	optional bool
+	rest     bool   // takes the remaining words
+	def      string // default value of flags
+}
+
+type command struct {
+	name        string
+	usage       string
+	description string
+	args        []commandArg
+	flags       map[string]commandArg
+	handler     CommandHandler
+}
+
+// CommandRouter is a SlackRTMHandler that runs the commands sent to the
+// bot, either by direct message or by mentioning it at the start of a
+// message (e.g. "@bot deploy api --env=staging").
+//
+// Commands are registered with a usage that describes their arguments:
+//
+//	deploy <service> [--env=prod]
+//
+// <name> is a required argument, and [name] an optional one. Appending
+// "..." to the last one (e.g. <text...>) makes it take the rest of the
+// message as it was typed. [--name=default] is a flag with a default, and [--name] a flag
+// that is "true" when given. Values that contain spaces may be quoted.
+//
+// Unless a command named "help" is registered, "help" lists the
+// commands, and "help <command>" describes one of them
+type CommandRouter struct {
+	server   *Server
+	mutex    sync.RWMutex
+	commands map[string]*command
+}
+
+// NewCommandRouter creates a new CommandRouter that replies through s
+func NewCommandRouter(s *Server) *CommandRouter {
+	return &CommandRouter{
+		server:   s,
+		commands: make(map[string]*command),
+	}
+}
+
+// Register adds a command. usage is parsed as described in CommandRouter,
+// and description is shown by "help"
+func (r *CommandRouter) Register(usage, description string, h CommandHandler) error {
+	cmd, err := parseUsage(usage)
+	if err != nil {
+		return errors.Wrapf(err, "invalid usage '%s'", usage)
+	}
+	cmd.description = description
+	cmd.handler = h
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.commands[cmd.name] = cmd
+	return nil
+}
+
+func parseUsage(usage string) (*command, error) {
+	words := strings.Fields(usage)
+	if len(words) == 0 {
+		return nil, errors.New("no command name")
+	}
+	cmd := &command{
+		name:  words[0],
+		usage: strings.Join(words, " "),
+		flags: make(map[string]commandArg),
+	}
+	if strings.ContainsAny(cmd.name, "<>[]") || strings.HasPrefix(cmd.name, "-") {
+		return nil, errors.Errorf("invalid command name '%s'", cmd.name)
+	}
+
+	for _, w := range words[1:] {
+		var arg commandArg
+		switch {
+		case len(w) > 2 && w[0] == '<' && w[len(w)-1] == '>':
+			arg.name = w[1 : len(w)-1]
+		case len(w) > 2 && w[0] == '[' && w[len(w)-1] == ']':
+			arg.name = w[1 : len(w)-1]
+			arg.optional = true
+		default:
+			return nil, errors.Errorf("invalid argument '%s'", w)
+		}
+
+		if strings.HasPrefix(arg.name, "--") {
+			if !arg.optional {
+				return nil, errors.Errorf("flag '%s' must be optional", w)
+			}
+			arg.name = strings.TrimPrefix(arg.name, "--")
+			if i := strings.IndexByte(arg.name, '='); i != -1 {
+				arg.name, arg.def = arg.name[:i], arg.name[i+1:]
+			}
+			if arg.name == "" {
+				return nil, errors.Errorf("invalid flag '%s'", w)
+			}
+			cmd.flags[arg.name] = arg
+			continue
+		}
+
+		if n := len(cmd.args); n > 0 {
+			last := cmd.args[n-1]
+			if last.rest {
+				return nil, errors.Errorf("argument '%s' follows '%s...'", w, last.name)
+			}
+			if last.optional && !arg.optional {
+				return nil, errors.Errorf("required argument '%s' follows optional argument '%s'", w, last.name)
+			}
+		}
+		if strings.HasSuffix(arg.name, "...") {
+			arg.name = strings.TrimSuffix(arg.name, "...")
+			arg.rest = true
+		}
+		if arg.name == "" {
+			return nil, errors.Errorf("invalid argument '%s'", w)
+		}
+		cmd.args = append(cmd.args, arg)
+	}
+	return cmd, nil
+}
+
+// parseArgs assigns words to the arguments and flags of the command.
+// tail returns the text of the message from the i-th word on, for the
+// argument that takes the rest of the message
+func (cmd *command) parseArgs(words []string, tail func(i int) string) (map[string]string, error) {
+	args := make(map[string]string)
+	for name, f := range cmd.flags {
+		if f.def != "" {
+			args[name] = f.def
+		}
+	}
+
+	var positional []string
+	for i, w := range words {
+		if len(cmd.args) > 0 && len(positional) == len(cmd.args)-1 && cmd.args[len(cmd.args)-1].rest {
+			positional = append(positional, tail(i))
+			break
+		}
+
+		if w == "--" {
+			positional = append(positional, words[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(w, "--") {
+			positional = append(positional, w)
+			continue
+		}
+
+		name, value := strings.TrimPrefix(w, "--"), "true"
+		if j := strings.IndexByte(name, '='); j != -1 {
+			name, value = name[:j], name[j+1:]
+		}
+		if _, ok := cmd.flags[name]; !ok {
+			return nil, errors.Errorf("unknown flag '--%s'", name)
+		}
+		args[name] = value
+	}
+
+	if len(positional) > len(cmd.args) {
+		return nil, errors.New("too many arguments")
+	}
+	for i, arg := range cmd.args {
+		if i >= len(positional) {
+			if !arg.optional {
+				return nil, errors.Errorf("missing argument '%s'", arg.name)
+			}
+			continue
+		}
+		args[arg.name] = positional[i]
+	}
+	return args, nil
+}
+
+// splitWords splits text at white space, keeping quoted strings (which
+// may have been turned into curly quotes by the client) together. It
+// also returns the offset in text at which each word starts
+func splitWords(text string) ([]string, []int, error) {
+	var words []string
+	var offsets []int
+	var buf bytes.Buffer
+	var quoted, inword bool
+	for i, c := range text {
+		if !inword && c != ' ' && c != '\t' && c != '\n' {
+			offsets = append(offsets, i)
+		}
+		switch {
+		case c == '"' || c == '“' || c == '”':
+			quoted = !quoted
+			inword = true
+		case !quoted && (c == ' ' || c == '\t' || c == '\n'):
+			if inword {
+				words = append(words, slackUnescaper.Replace(buf.String()))
+				buf.Reset()
+				inword = false
+			}
+		default:
+			buf.WriteRune(c)
+			inword = true
+		}
+	}
+	if quoted {
+		return nil, nil, errors.New("unterminated quote")
+	}
+	if inword {
+		words = append(words, slackUnescaper.Replace(buf.String()))
+	}
+	return words, offsets, nil
+}
+
+// isDirectMessage checks if channel is the ID of a direct message channel
+func isDirectMessage(channel string) bool {
+	return strings.HasPrefix(channel, "D")
+}
+
+func (r *CommandRouter) Handle(ctx *RTMCtx) error {
+	m, ok := ctx.Event.Data.(*slack.MessageEvent)
+	// Ignore edits, bot messages and such, as well as our own messages
+	if !ok || m.SubType != "" || m.User == ctx.UserID {
+		return nil
+	}
+
+	text, ok := AddressedTo(m.Text, ctx.UserID)
+	if !ok {
+		if !isDirectMessage(m.Channel) {
+			return nil
+		}
+		text = m.Text
+	}
+
+	// Commands may take a while, and must not hold up the RTM loop
+	go r.run(&Command{Ctx: ctx, Message: m, router: r}, text)
+	return nil
+}
+
+func (r *CommandRouter) run(c *Command, text string) {
+	if pdebug.Enabled {
+		pdebug.Printf("rtm: command '%s'...", text)
+		defer pdebug.Printf("done with command")
+	}
+
+	reply := func(text string) {
+		if err := c.Reply(text); err != nil && pdebug.Enabled {
+			pdebug.Printf("failed to reply: %s", err)
+		}
+	}
+
+	words, offsets, err := splitWords(text)
+	if err != nil {
+		reply(err.Error())
+		return
+	}
+	if len(words) == 0 {
+		words = []string{"help"}
+	}
+	c.Name = words[0]
+
+	r.mutex.RLock()
+	cmd, ok := r.commands[c.Name]
+	r.mutex.RUnlock()
+	if !ok {
+		if c.Name == "help" {
+			reply(r.help(words[1:]))
+			return
+		}
+		reply("Unknown command `" + c.Name + "`. Try `help`")
+		return
+	}
+
+	tail := func(i int) string {
+		return slackUnescaper.Replace(strings.TrimSpace(text[offsets[i+1]:]))
+	}
+	c.Args, err = cmd.parseArgs(words[1:], tail)
+	if err != nil {
+		reply(err.Error() + "\nUsage: `" + cmd.usage + "`")
+		return
+	}
+
+	if err := cmd.handler(c); err != nil {
+		reply("`" + c.Name + "` failed: " + err.Error())
+	}
+}
+
+// help generates the output of the "help" command
+func (r *CommandRouter) help(args []string) string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if len(args) > 0 {
+		cmd, ok := r.commands[args[0]]
+		if !ok {
+			return "Unknown command `" + args[0] + "`. Try `help`"
+		}
+		return "`" + cmd.usage + "`\n" + cmd.description
+	}
+
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString("Available commands:")
+	for _, name := range names {
+		cmd := r.commands[name]
+		buf.WriteString("\n`" + cmd.usage + "`")
+		if cmd.description != "" {
+			buf.WriteString(" " + cmd.description)
+		}
+	}
+	buf.WriteString("\n`help [command]` show this help")
+	return buf.String()
+}
@@ -0,0 +1,93 @@
+package slackgw
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestCommandRouter(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	received := make(chan *Message, 1)
+	go func() {
+		for msg := range s.bus {
+			c := *msg
+			received <- &c
+			msg.dst <- nil
+		}
+	}()
+
+	r := NewCommandRouter(s)
+	if err := r.Register("deploy <service> [--env=prod] [--force]", "deploy a service", func(c *Command) error {
+		if c.Arg("service") == "db" {
+			return errors.New("not allowed")
+		}
+		return c.Reply(c.Arg("service") + " to " + c.Arg("env") + " force=" + c.Arg("force"))
+	}); err != nil {
+		t.Fatalf("failed to register command: %s", err)
+	}
+	if err := r.Register("say <channel> <text...>", "say something", func(c *Command) error {
+		return c.Reply(c.Arg("channel") + ": " + c.Arg("text"))
+	}); err != nil {
+		t.Fatalf("failed to register command: %s", err)
+	}
+	if err := r.Register("bogus [a] <b>", "", nil); err == nil {
+		t.Errorf("required argument after optional one should be rejected")
+	}
+
+	for _, test := range []struct {
+		channel  string
+		thread   string
+		text     string
+		expected string // prefix of the reply, or empty if the message is ignored
+	}{
+		{"C1", "", "deploy api", ""},
+		{"C1", "", "<@U12345> deploy api", "api to prod force="},
+		{"D1", "", "deploy api --env=staging --force", "api to staging force=true"},
+		{"C1", "1234.5678", "<@U12345|bot>: deploy “my api” --env=dev", "my api to dev force="},
+		{"D1", "", "say #general hello  &lt;world&gt;", "#general: hello  <world>"},
+		{"D1", "", "say #general \"quoted\"\tline one\nline two ", "#general: \"quoted\"\tline one\nline two"},
+		{"D1", "", "deploy db", "`deploy` failed: not allowed"},
+		{"D1", "", "deploy", "missing argument 'service'\nUsage: `deploy <service> [--env=prod] [--force]`"},
+		{"D1", "", "deploy api --region=us", "unknown flag '--region'"},
+		{"D1", "", "rollback", "Unknown command `rollback`"},
+		{"D1", "", "help", "Available commands:\n`deploy <service> [--env=prod] [--force]` deploy a service\n`say <channel> <text...>` say something\n`help [command]`"},
+		{"D1", "", "help say", "`say <channel> <text...>`\nsay something"},
+	} {
+		m := &slack.MessageEvent{}
+		m.Channel = test.channel
+		m.ThreadTimestamp = test.thread
+		m.User = "U99999"
+		m.Text = test.text
+		if err := r.Handle(&RTMCtx{UserID: "U12345", Event: slack.RTMEvent{Type: "message", Data: m}}); err != nil {
+			t.Fatalf("failed to handle '%s': %s", test.text, err)
+		}
+
+		timeout := time.Second
+		if test.expected == "" {
+			timeout = 50 * time.Millisecond
+		}
+		select {
+		case msg := <-received:
+			if test.expected == "" {
+				t.Errorf("'%s' should be ignored, got reply '%s'", test.text, msg.Message)
+				continue
+			}
+			if !strings.HasPrefix(msg.Message, test.expected) {
+				t.Errorf("'%s': expected reply '%s', got '%s'", test.text, test.expected, msg.Message)
+			}
+			if msg.Channel != test.channel || msg.ThreadTimestamp != test.thread {
+				t.Errorf("'%s': reply sent to %s (thread '%s')", test.text, msg.Channel, msg.ThreadTimestamp)
+			}
+		case <-time.After(timeout):
+			if test.expected != "" {
+				t.Errorf("'%s': timed out waiting for reply", test.text)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package slackgw
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nlopes/slack"
+)
+
+// eventHub fans RTM events out to streaming clients. Subscribers that
+// cannot keep up miss events (see StreamDropPolicy), instead of holding
+// up everybody else
+type eventHub struct {
+	mutex sync.Mutex
+	subs  map[*eventSub]struct{}
+}
+
+type eventSub struct {
+	ch      chan slack.RTMEvent
+	filter  *eventFilter // if non nil, only matching events are sent
+	policy  string
+	dropped uint64        // number of events dropped since the last call to takeDropped. Accessed atomically
+	gone    chan struct{} // closed when the subscriber is disconnected by DropDisconnect
+}
+
+// takeDropped returns the number of events dropped since the last call
+func (sub *eventSub) takeDropped() uint64 {
+	return atomic.SwapUint64(&sub.dropped, 0)
+}
+
+func (h *eventHub) subscribe(size int, policy string, f *eventFilter) *eventSub {
+	sub := &eventSub{
+		ch:     make(chan slack.RTMEvent, size),
+		filter: f,
+		policy: policy,
+		gone:   make(chan struct{}),
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[*eventSub]struct{})
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *eventSub) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.subs, sub)
+}
+
+func (h *eventHub) publish(ev slack.RTMEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for sub := range h.subs {
+		if sub.filter != nil && !sub.filter.match(ev) {
+			continue
+		}
+
+		select {
+		case sub.ch <- ev:
+			continue
+		default:
+		}
+
+		if pdebug.Enabled {
+			pdebug.Printf("buffer full, applying '%s' policy to %s event", sub.policy, ev.Type)
+		}
+		switch sub.policy {
+		case DropDisconnect:
+			delete(h.subs, sub)
+			close(sub.gone)
+			continue
+		case DropOldest:
+			// Only we send to sub.ch, so there is room once we take one out
+			select {
+			case <-sub.ch:
+			default:
+			}
+			sub.ch <- ev
+		}
+		atomic.AddUint64(&sub.dropped, 1)
+	}
+}
+
+// eventName returns the name of the event, as used by EventNameToMask
+// (e.g. "MessageEvent"), or an empty string if it is not one of
+// registeredEvents
+func eventName(ev slack.RTMEvent) string {
+	return eventNames[MaskOf(ev)]
+}
@@ -1,70 +1,107 @@
 package slackgw
 
-import "math"
+import (
+	"reflect"
 
-var eventNames = []string{
-	"Invalid",
-	"AccountsChangedEvent",
-	"AckErrorEvent",
-	"BotAddedEvent",
-	"BotChangedEvent",
-	"ChannelCreatedEvent",
-	"ChannelHistoryChangedEvent",
-	"ChannelInfoEvent",
-	"ChannelJoinedEvent",
-	"ChannelRenameEvent",
-	"CommandsChangedEvent",
-	"ConnectedEvent",
-	"ConnectingEvent",
-	"ConnectionErrorEvent",
-	"DNDUpdatedEvent",
-	"DisconnectedEvent",
-	"EmailDomainChangedEvent",
-	"EmojiChangedEvent",
-	"FileCommentAddedEvent",
-	"FileCommentDeletedEvent",
-	"FileCommentEditedEvent",
-	"GroupCreatedEvent",
-	"GroupRenameEvent",
-	"HelloEvent",
-	"IMCreatedEvent",
-	"InvalidAuthEvent",
-	"ManualPresenceChangeEvent",
-	"MessageEvent",
-	"MessageTooLongEvent",
-	"OutgoingErrorEvent",
-	"PinAddedEvent",
-	"PinRemovedEvent",
-	"PrefChangeEvent",
-	"PresenceChangeEvent",
-	"ReactionAddedEvent",
-	"ReactionRemovedEvent",
-	"ReconnectUrlEvent",
-	"StarAddedEvent",
-	"StarRemovedEvent",
-	"TeamDomainChangeEvent",
-	"TeamJoinEvent",
-	"TeamMigrationStartedEvent",
-	"TeamPrefChangeEvent",
-	"TeamRenameEvent",
-	"UnmarshallingErrorEvent",
-	"UserChangeEvent",
-	"UserTypingEvent",
+	"github.com/nlopes/slack"
+)
+
+// registeredEvents lists the RTM events slackgw knows about, along with
+// their masks (see the constants in interface.go). The names used by
+// EventNameToMask are those of the types. To support another event, add
+// a constant right before MaxEvent, and its type here
+var registeredEvents = []struct {
+	data interface{}
+	mask int64
+}{
+	{(*slack.AccountsChangedEvent)(nil), AccountsChangedEvent},
+	{(*slack.AckErrorEvent)(nil), AckErrorEvent},
+	{(*slack.BotAddedEvent)(nil), BotAddedEvent},
+	{(*slack.BotChangedEvent)(nil), BotChangedEvent},
+	{(*slack.ChannelCreatedEvent)(nil), ChannelCreatedEvent},
+	{(*slack.ChannelHistoryChangedEvent)(nil), ChannelHistoryChangedEvent},
+	{(*slack.ChannelInfoEvent)(nil), ChannelInfoEvent},
+	{(*slack.ChannelJoinedEvent)(nil), ChannelJoinedEvent},
+	{(*slack.ChannelRenameEvent)(nil), ChannelRenameEvent},
+	{(*slack.CommandsChangedEvent)(nil), CommandsChangedEvent},
+	{(*slack.ConnectedEvent)(nil), ConnectedEvent},
+	{(*slack.ConnectingEvent)(nil), ConnectingEvent},
+	{(*slack.ConnectionErrorEvent)(nil), ConnectionErrorEvent},
+	{(*slack.DNDUpdatedEvent)(nil), DNDUpdatedEvent},
+	{(*slack.DisconnectedEvent)(nil), DisconnectedEvent},
+	{(*slack.EmailDomainChangedEvent)(nil), EmailDomainChangedEvent},
+	{(*slack.EmojiChangedEvent)(nil), EmojiChangedEvent},
+	{(*slack.FileCommentAddedEvent)(nil), FileCommentAddedEvent},
+	{(*slack.FileCommentDeletedEvent)(nil), FileCommentDeletedEvent},
+	{(*slack.FileCommentEditedEvent)(nil), FileCommentEditedEvent},
+	{(*slack.GroupCreatedEvent)(nil), GroupCreatedEvent},
+	{(*slack.GroupRenameEvent)(nil), GroupRenameEvent},
+	{(*slack.HelloEvent)(nil), HelloEvent},
+	{(*slack.IMCreatedEvent)(nil), IMCreatedEvent},
+	{(*slack.InvalidAuthEvent)(nil), InvalidAuthEvent},
+	{(*slack.ManualPresenceChangeEvent)(nil), ManualPresenceChangeEvent},
+	{(*slack.MessageEvent)(nil), MessageEvent},
+	{(*slack.MessageTooLongEvent)(nil), MessageTooLongEvent},
+	{(*slack.OutgoingErrorEvent)(nil), OutgoingErrorEvent},
+	{(*slack.PinAddedEvent)(nil), PinAddedEvent},
+	{(*slack.PinRemovedEvent)(nil), PinRemovedEvent},
+	{(*slack.PrefChangeEvent)(nil), PrefChangeEvent},
+	{(*slack.PresenceChangeEvent)(nil), PresenceChangeEvent},
+	{(*slack.ReactionAddedEvent)(nil), ReactionAddedEvent},
+	{(*slack.ReactionRemovedEvent)(nil), ReactionRemovedEvent},
+	{(*slack.ReconnectUrlEvent)(nil), ReconnectUrlEvent},
+	{(*slack.StarAddedEvent)(nil), StarAddedEvent},
+	{(*slack.StarRemovedEvent)(nil), StarRemovedEvent},
+	{(*slack.TeamDomainChangeEvent)(nil), TeamDomainChangeEvent},
+	{(*slack.TeamJoinEvent)(nil), TeamJoinEvent},
+	{(*slack.TeamMigrationStartedEvent)(nil), TeamMigrationStartedEvent},
+	{(*slack.TeamPrefChangeEvent)(nil), TeamPrefChangeEvent},
+	{(*slack.TeamRenameEvent)(nil), TeamRenameEvent},
+	{(*slack.UnmarshallingErrorEvent)(nil), UnmarshallingErrorEvent},
+	{(*slack.UserChangeEvent)(nil), UserChangeEvent},
+	{(*slack.UserTypingEvent)(nil), UserTypingEvent},
+	{(*slack.ChannelArchiveEvent)(nil), ChannelArchiveEvent},
+	{(*slack.MemberJoinedChannelEvent)(nil), MemberJoinedChannelEvent},
+}
+
+var (
+	eventNames = make(map[int64]string)       // by mask
+	eventMasks = make(map[reflect.Type]int64) // by the type of the event
+)
+
+func init() {
+	for _, ev := range registeredEvents {
+		t := reflect.TypeOf(ev.data).Elem()
+		eventNames[ev.mask] = t.Name()
+		eventMasks[t] = ev.mask
+	}
+}
+
+// MaskOf returns the mask of the event (e.g. MessageEvent for a
+// *slack.MessageEvent), or 0 if it is not one of registeredEvents
+func MaskOf(ev slack.RTMEvent) int64 {
+	t := reflect.TypeOf(ev.Data)
+	if t == nil {
+		return 0
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return eventMasks[t]
 }
 
 func EventNameToMask(name string) int64 {
-	for i, n := range eventNames {
+	for mask, n := range eventNames {
 		if n == name {
-			return int64(1<<uint64(i))
+			return mask
 		}
 	}
 	return -1
 }
 
 func MaskToEventName(v int64) string {
-	iv := int(math.Log2(float64(v)))
-	if iv < 0 || iv >= len(eventNames) {
-		iv = 0
+	if n, ok := eventNames[v]; ok {
+		return n
 	}
-	return eventNames[iv]
+	return "Invalid"
 }
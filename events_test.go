@@ -1,6 +1,10 @@
 package slackgw
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/nlopes/slack"
+)
 
 func TestEventString(t *testing.T) {
 	for i := AccountsChangedEvent; i < MaxEvent; i = i << 1 {
@@ -14,4 +18,46 @@ func TestEventString(t *testing.T) {
 	if n := EventNameToMask("MessageEvent"); n != MessageEvent {
 		t.Errorf("MessageEvent string should yield the correct mask, got %d", n)
 	}
-}
\ No newline at end of file
+}
+
+func TestMaskOf(t *testing.T) {
+	// Every constant has exactly one event
+	for mask := AccountsChangedEvent; mask < MaxEvent; mask <<= 1 {
+		var n int
+		for _, ev := range registeredEvents {
+			if ev.mask == mask {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Errorf("expected 1 event for %s (%d), got %d", MaskToEventName(mask), mask, n)
+		}
+	}
+
+	for _, test := range []struct {
+		data interface{}
+		mask int64
+	}{
+		{&slack.MessageEvent{}, MessageEvent},
+		{&slack.ChannelArchiveEvent{}, ChannelArchiveEvent},
+		{&slack.MemberJoinedChannelEvent{}, MemberJoinedChannelEvent},
+		{nil, 0},
+		{struct{}{}, 0},
+	} {
+		if mask := MaskOf(slack.RTMEvent{Data: test.data}); mask != test.mask {
+			t.Errorf("expected mask %d for %T, got %d", test.mask, test.data, mask)
+		}
+	}
+
+	for _, name := range []string{"ChannelArchiveEvent", "MemberJoinedChannelEvent"} {
+		if MaskToEventName(EventNameToMask(name)) != name {
+			t.Errorf("%s should be a known event", name)
+		}
+	}
+	if name := eventName(slack.RTMEvent{Data: &slack.ReactionAddedEvent{}}); name != "ReactionAddedEvent" {
+		t.Errorf("expected 'ReactionAddedEvent', got '%s'", name)
+	}
+	if name := eventName(slack.RTMEvent{Data: struct{}{}}); name != "" {
+		t.Errorf("expected no name for an unknown event, got '%s'", name)
+	}
+}
@@ -0,0 +1,344 @@
+package slackgw
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+// Defaults for WebhookForwarder
+const (
+	DefaultForwardBatchSize     = 100
+	DefaultForwardFlushInterval = time.Second
+)
+
+// number of batches queued for each URL before they are dead-lettered
+const forwardQueueSize = 16
+
+// WebhookForwarder is a SlackRTMHandler that POSTs the selected events
+// as JSON to one or more URLs:
+//
+//	{"events":[{"name":"MessageEvent","type":"message","data":{...}},...]}
+//
+// Events are sent in batches of up to BatchSize, at least every
+// FlushInterval. If Secret is non empty, each request is signed like
+// requests to slackgw itself (see ComputeSignature), using the
+// X-Slackgw-Timestamp and X-Slackgw-Signature headers.
+//
+// Failed deliveries are retried according to Retry. Batches that still
+// cannot be delivered are written to DeadLetterDir, if specified, and
+// dropped otherwise. The exported fields must be set before the first
+// event is handled.
+//
+// Close flushes the events that are still held: each pending batch gets
+// one more delivery attempt, and is dead-lettered if it fails
+type WebhookForwarder struct {
+	Secret            string        // if non empty, used to sign requests
+	SelfAddressedOnly bool          // only forward messages that are addressed to this bot
+	BatchSize         int           // max number of events per request. If 0, DefaultForwardBatchSize
+	FlushInterval     time.Duration // max time events are held before being sent. If 0, DefaultForwardFlushInterval
+	Retry             RetryPolicy   // how to retry failed deliveries
+	DeadLetterDir     string        // where batches that could not be delivered are written
+	Client            *http.Client  // if nil, http.DefaultClient
+
+	initonce  sync.Once
+	closeonce sync.Once
+	mask      int64
+	urls      []string
+	evch      chan slack.RTMEvent
+	done      chan struct{} // closed by Close
+	wg        sync.WaitGroup
+}
+
+// forwardBatch is the payload sent by WebhookForwarder
+type forwardBatch struct {
+	Events []*StreamEvent `json:"events"`
+}
+
+// deadLetter is what WebhookForwarder writes to DeadLetterDir
+type deadLetter struct {
+	URL     string          `json:"url"`
+	Error   string          `json:"error"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// NewWebhookForwarder creates a new WebhookForwarder that forwards the
+// specified events to urls
+func NewWebhookForwarder(urls []string, secret string, events ...int64) *WebhookForwarder {
+	var mask int64
+	for _, ev := range events {
+		mask |= ev
+	}
+
+	return &WebhookForwarder{
+		Secret: secret,
+		Retry:  DefaultRetryPolicy,
+		mask:   mask,
+		urls:   urls,
+		done:   make(chan struct{}),
+	}
+}
+
+func (f *WebhookForwarder) batchSize() int {
+	if f.BatchSize > 0 {
+		return f.BatchSize
+	}
+	return DefaultForwardBatchSize
+}
+
+func (f *WebhookForwarder) flushInterval() time.Duration {
+	if f.FlushInterval > 0 {
+		return f.FlushInterval
+	}
+	return DefaultForwardFlushInterval
+}
+
+func (f *WebhookForwarder) client() *http.Client {
+	if f.Client != nil {
+		return f.Client
+	}
+	return http.DefaultClient
+}
+
+func (f *WebhookForwarder) Handle(ctx *RTMCtx) error {
+	ev := ctx.Event
+
+	f.initonce.Do(f.start)
+
+	if f.mask&MaskOf(ev) == 0 {
+		return nil
+	}
+
+	if f.SelfAddressedOnly {
+		m, ok := ev.Data.(*slack.MessageEvent)
+		if !ok {
+			return nil
+		}
+		if _, ok := AddressedTo(m.Text, ctx.UserID); !ok {
+			return nil
+		}
+	}
+
+	select {
+	case <-f.done:
+		return errors.New("forwarder is closed")
+	default:
+	}
+
+	select {
+	case f.evch <- ev:
+	case <-f.done:
+		return errors.New("forwarder is closed")
+	}
+	return nil
+}
+
+// Close stops forwarding events. It waits until the events that were
+// already handled have been delivered or dead-lettered
+func (f *WebhookForwarder) Close() error {
+	f.closeonce.Do(func() {
+		// Make sure start is not called after this point
+		f.initonce.Do(func() {})
+		close(f.done)
+		f.wg.Wait()
+	})
+	return nil
+}
+
+// start starts a sender for each URL, and the loop that batches events
+func (f *WebhookForwarder) start() {
+	f.evch = make(chan slack.RTMEvent, f.batchSize())
+
+	queues := make([]chan []byte, len(f.urls))
+	for i, u := range f.urls {
+		queues[i] = make(chan []byte, forwardQueueSize)
+		f.wg.Add(1)
+		go f.sender(u, queues[i])
+	}
+	f.wg.Add(1)
+	go f.loop(queues)
+}
+
+func (f *WebhookForwarder) loop(queues []chan []byte) {
+	if pdebug.Enabled {
+		pdebug.Printf("Start slackgw.WebhookForwarder.loop()")
+		defer pdebug.Printf("Bailing out of slackgw.WebhookForwarder.loop()")
+	}
+
+	defer f.wg.Done()
+
+	flusht := time.NewTicker(f.flushInterval())
+	defer flusht.Stop()
+
+	size := f.batchSize()
+	batch := forwardBatch{Events: make([]*StreamEvent, 0, size)}
+	add := func(ev slack.RTMEvent) {
+		if se, ok := newStreamEvent(ev, 0); ok {
+			batch.Events = append(batch.Events, se)
+		}
+	}
+	flush := func() {
+		if len(batch.Events) == 0 {
+			return
+		}
+		payload, err := json.Marshal(&batch)
+		batch.Events = batch.Events[:0]
+		if err != nil {
+			if pdebug.Enabled {
+				pdebug.Printf("failed to encode events: %s", err)
+			}
+			return
+		}
+
+		for i, q := range queues {
+			select {
+			case q <- payload:
+			default:
+				f.deadLetter(f.urls[i], payload, errors.New("queue is full"))
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-f.done:
+			// Flush whatever Handle queued before we were closed, and
+			// let the senders drain their queues
+		drain:
+			for {
+				select {
+				case ev := <-f.evch:
+					add(ev)
+					if len(batch.Events) >= size {
+						flush()
+					}
+				default:
+					break drain
+				}
+			}
+			flush()
+			for _, q := range queues {
+				close(q)
+			}
+			return
+		case ev := <-f.evch:
+			add(ev)
+			if len(batch.Events) >= size {
+				flush()
+			}
+		case <-flusht.C:
+			flush()
+		}
+	}
+}
+
+// sender delivers the batches queued for u, one at a time
+func (f *WebhookForwarder) sender(u string, q <-chan []byte) {
+	defer f.wg.Done()
+
+	for payload := range q {
+		if err := f.deliverWithRetry(u, payload); err != nil {
+			f.deadLetter(u, payload, err)
+		}
+	}
+}
+
+func (f *WebhookForwarder) deliverWithRetry(u string, payload []byte) error {
+	policy := f.Retry
+	for attempt := 1; ; attempt++ {
+		retry, err := f.deliver(u, payload)
+		if err == nil || !retry || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		wait := policy.Backoff(attempt)
+		if pdebug.Enabled {
+			pdebug.Printf("attempt %d to forward to %s failed (%s), retrying in %s", attempt, u, err, wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-f.done:
+			return errors.Wrap(err, "forwarder was closed before delivery succeeded")
+		}
+	}
+}
+
+// deliver sends payload to u once. retry is true if the error is
+// worth retrying
+func (f *WebhookForwarder) deliver(u string, payload []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", u, bytes.NewReader(payload))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if f.Secret != "" {
+		ts := time.Now().Unix()
+		req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+		req.Header.Set(SignatureHeader, ComputeSignature(f.Secret, req.Method, req.URL.RequestURI(), ts, payload))
+	}
+
+	res, err := f.client().Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	switch {
+	case res.StatusCode/100 == 2:
+		return false, nil
+	case res.StatusCode/100 == 5, res.StatusCode == http.StatusTooManyRequests:
+		return true, errors.New(res.Status)
+	default:
+		return false, errors.New(res.Status)
+	}
+}
+
+// deadLetter writes a batch that could not be delivered to u to the
+// dead letter directory
+func (f *WebhookForwarder) deadLetter(u string, payload []byte, err error) {
+	if pdebug.Enabled {
+		pdebug.Printf("failed to forward %d bytes to %s: %s", len(payload), u, err)
+	}
+	if f.DeadLetterDir == "" {
+		return
+	}
+
+	if werr := f.writeDeadLetter(&deadLetter{URL: u, Error: err.Error(), Payload: payload}); werr != nil && pdebug.Enabled {
+		pdebug.Printf("failed to write dead letter: %s", werr)
+	}
+}
+
+// writeDeadLetter writes dl to a hidden file first, so that whoever
+// processes the dead letters never sees a partial file
+func (f *WebhookForwarder) writeDeadLetter(dl *deadLetter) error {
+	if err := os.MkdirAll(f.DeadLetterDir, 0700); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(f.DeadLetterDir, ".deadletter-")
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(file).Encode(dl); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), filepath.Join(f.DeadLetterDir, strings.TrimPrefix(filepath.Base(file.Name()), ".")))
+}
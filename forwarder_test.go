@@ -0,0 +1,154 @@
+package slackgw
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestWebhookForwarder(t *testing.T) {
+	received := make(chan forwardBatch, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+		if sig := ComputeSignature("sh4r3d", r.Method, r.URL.RequestURI(), ts, body); sig != r.Header.Get(SignatureHeader) {
+			t.Errorf("invalid signature '%s'", r.Header.Get(SignatureHeader))
+		}
+
+		var batch forwardBatch
+		if err := json.Unmarshal(body, &batch); err != nil {
+			t.Errorf("failed to decode batch: %s", err)
+		}
+		received <- batch
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "slackgw-deadletter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+
+	f := NewWebhookForwarder([]string{s.URL + "/slack", rejecting.URL}, "sh4r3d", MessageEvent)
+	f.SelfAddressedOnly = true
+	f.BatchSize = 2
+	f.FlushInterval = 10 * time.Millisecond
+	f.DeadLetterDir = dir
+
+	for _, ev := range []slack.RTMEvent{
+		{Type: "hello", Data: &slack.HelloEvent{}},
+		newTestMessageEvent("C1", "not for us"),
+		newTestMessageEvent("C1", "<@U12345> deploy"),
+	} {
+		if err := f.Handle(&RTMCtx{UserID: "U12345", Event: ev}); err != nil {
+			t.Fatalf("failed to handle event: %s", err)
+		}
+	}
+
+	select {
+	case batch := <-received:
+		if len(batch.Events) != 1 || batch.Events[0].Name != "MessageEvent" {
+			t.Errorf("unexpected batch: %#v", batch)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for events")
+	}
+
+	timeout := time.After(time.Second)
+	for {
+		files, _ := filepath.Glob(filepath.Join(dir, "deadletter-*"))
+		if len(files) == 1 {
+			buf, _ := ioutil.ReadFile(files[0])
+			var dl deadLetter
+			if err := json.Unmarshal(buf, &dl); err != nil || dl.URL != rejecting.URL || dl.Error != "400 Bad Request" {
+				t.Errorf("unexpected dead letter: %s", buf)
+			}
+			break
+		}
+
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for dead letter")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestWebhookForwarderClose(t *testing.T) {
+	received := make(chan forwardBatch, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch forwardBatch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("failed to decode batch: %s", err)
+		}
+		received <- batch
+	}))
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "slackgw-deadletter")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	// Nothing would be sent before Close, and the failing URL would be
+	// retried for an hour
+	f := NewWebhookForwarder([]string{s.URL, failing.URL}, "", MessageEvent)
+	f.BatchSize = 10
+	f.FlushInterval = time.Hour
+	f.Retry = RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}
+	f.DeadLetterDir = dir
+
+	for _, text := range []string{"one", "two"} {
+		if err := f.Handle(&RTMCtx{Event: newTestMessageEvent("C1", text)}); err != nil {
+			t.Fatalf("failed to handle event: %s", err)
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		f.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for Close")
+	}
+
+	select {
+	case batch := <-received:
+		if len(batch.Events) != 2 {
+			t.Errorf("expected 2 events, got %#v", batch)
+		}
+	default:
+		t.Errorf("pending events were not flushed")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "deadletter-*"))
+	if len(files) != 1 {
+		t.Errorf("expected 1 dead letter, got %v", files)
+	}
+
+	if err := f.Handle(&RTMCtx{Event: newTestMessageEvent("C1", "three")}); err == nil {
+		t.Errorf("expected an error after Close")
+	}
+}
@@ -0,0 +1,221 @@
+package slackgw
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+)
+
+// Headers sent by GitHub webhooks
+const (
+	GitHubEventHeader     = "X-GitHub-Event"
+	GitHubSignatureHeader = "X-Hub-Signature-256"
+)
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+type githubEvent struct {
+	Action     string     `json:"action"`
+	Sender     githubUser `json:"sender"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+
+	// push
+	Ref     string `json:"ref"`
+	Compare string `json:"compare"`
+	Deleted bool   `json:"deleted"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+
+	// pull_request
+	PullRequest *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+	} `json:"pull_request"`
+
+	// release
+	Release *struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		HTMLURL string `json:"html_url"`
+	} `json:"release"`
+
+	// workflow_run
+	WorkflowRun *struct {
+		Name       string `json:"name"`
+		HeadBranch string `json:"head_branch"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+
+	// status
+	State     string `json:"state"`
+	Context   string `json:"context"`
+	TargetURL string `json:"target_url"`
+	Branches  []struct {
+		Name string `json:"name"`
+	} `json:"branches"`
+	SHA string `json:"sha"`
+}
+
+// verifyGitHubSignature checks the HMAC-SHA256 signature GitHub computes
+// over the body using the webhook's secret
+func verifyGitHubSignature(secret string, body []byte, sig string) error {
+	if !strings.HasPrefix(sig, "sha256=") {
+		return errors.New("missing or unsupported signature")
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+	if err != nil {
+		return errors.Wrap(err, "malformed signature")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// githubMessage converts a GitHub event into a message. Returns nil for
+// events (or actions) that are not worth posting
+func githubMessage(event string, ev *githubEvent) *Message {
+	repo, repoURL := ev.Repository.FullName, ev.Repository.HTMLURL
+	switch event {
+	case "push":
+		if ev.Deleted || len(ev.Commits) == 0 {
+			return nil
+		}
+		commits := make([]repoCommit, len(ev.Commits))
+		for i, c := range ev.Commits {
+			commits[i] = repoCommit{ID: c.ID, Message: c.Message, URL: c.URL, Author: c.Author.Name}
+		}
+		return repoPushMessage(repo, repoURL, ev.Sender.Login, ev.Ref, ev.Compare, len(commits), commits)
+	case "pull_request":
+		if ev.PullRequest == nil {
+			return nil
+		}
+		action := ev.Action
+		switch action {
+		case "opened", "reopened", "ready_for_review":
+		case "closed":
+			if ev.PullRequest.Merged {
+				action = "merged"
+			}
+		default:
+			return nil
+		}
+		pr := ev.PullRequest
+		return repoPullRequestMessage(repo, repoURL, "pull request", "#"+strconv.Itoa(pr.Number), pr.Title, pr.HTMLURL, ev.Sender.Login, action, pr.Body)
+	case "release":
+		if ev.Release == nil || ev.Action != "published" {
+			return nil
+		}
+		return repoReleaseMessage(repo, repoURL, ev.Sender.Login, ev.Release.TagName, ev.Release.Name, ev.Release.HTMLURL)
+	case "workflow_run":
+		run := ev.WorkflowRun
+		if run == nil || ev.Action != "completed" {
+			return nil
+		}
+		status := run.Conclusion
+		if status != "success" && status != "failure" {
+			status = "cancelled"
+		}
+		return repoCIMessage(repo, repoURL, run.Name, run.HeadBranch, status, run.HTMLURL)
+	case "status":
+		var status string
+		switch ev.State {
+		case "success", "failure":
+			status = ev.State
+		case "error":
+			status = "failure"
+		default:
+			return nil
+		}
+		ref := shortSHA(ev.SHA)
+		if len(ev.Branches) > 0 {
+			ref = ev.Branches[0].Name
+		}
+		return repoCIMessage(repo, repoURL, ev.Context, ref, status, ev.TargetURL)
+	}
+	return nil
+}
+
+// httpGitHub accepts GitHub webhooks. Deliveries are verified using
+// Server.GitHubSecret, and posted to the channel for the repository
+func (s *Server) httpGitHub(w http.ResponseWriter, r *http.Request) {
+	if pdebug.Enabled {
+		pdebug.Printf("http: github request...")
+		defer pdebug.Printf("done with github request")
+	}
+
+	if strings.ToLower(r.Method) != "post" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.repoWebhookAllowed(s.GitHubSecret) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRepoHookBodySize))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	if s.GitHubSecret != "" {
+		if err := verifyGitHubSignature(s.GitHubSecret, body, r.Header.Get(GitHubSignatureHeader)); err != nil {
+			if pdebug.Enabled {
+				pdebug.Printf("failed to verify github signature: %s", err)
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+
+	event := r.Header.Get(GitHubEventHeader)
+	if event == "ping" {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("pong"))
+		return
+	}
+
+	var ev githubEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to decode github event: %s", err)
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	msg := githubMessage(event, &ev)
+	if msg == nil {
+		ignoreRepoEvent(w)
+		return
+	}
+	s.postRepoMessage(w, ev.Repository.FullName, msg)
+}
@@ -0,0 +1,172 @@
+package slackgw
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lestrrat/go-pdebug"
+)
+
+// GitLabTokenHeader is the header GitLab webhooks send the secret token in
+const GitLabTokenHeader = "X-Gitlab-Token"
+
+type gitlabEvent struct {
+	ObjectKind string `json:"object_kind"`
+	UserName   string `json:"user_name"`
+	User       struct {
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	} `json:"user"`
+	Project struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+		WebURL            string `json:"web_url"`
+	} `json:"project"`
+
+	// push
+	Ref               string `json:"ref"`
+	Before            string `json:"before"`
+	After             string `json:"after"`
+	TotalCommitsCount int    `json:"total_commits_count"`
+	Commits           []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+
+	// merge_request and pipeline
+	ObjectAttributes struct {
+		ID          int    `json:"id"`
+		IID         int    `json:"iid"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		URL         string `json:"url"`
+		Action      string `json:"action"`
+		Ref         string `json:"ref"`
+		Status      string `json:"status"`
+	} `json:"object_attributes"`
+
+	// release
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	Tag    string `json:"tag"`
+	URL    string `json:"url"`
+}
+
+// gitlabMessage converts a GitLab event into a message. Returns nil for
+// events (or actions) that are not worth posting
+func gitlabMessage(ev *gitlabEvent) *Message {
+	repo, repoURL := ev.Project.PathWithNamespace, ev.Project.WebURL
+	user := ev.User.Username
+	if user == "" {
+		user = ev.UserName
+	}
+
+	switch ev.ObjectKind {
+	case "push", "tag_push":
+		if len(ev.Commits) == 0 || strings.Trim(ev.After, "0") == "" {
+			return nil
+		}
+		commits := make([]repoCommit, len(ev.Commits))
+		for i, c := range ev.Commits {
+			commits[i] = repoCommit{ID: c.ID, Message: c.Message, URL: c.URL, Author: c.Author.Name}
+		}
+		var compareURL string
+		if strings.Trim(ev.Before, "0") != "" {
+			compareURL = repoURL + "/-/compare/" + ev.Before + "..." + ev.After
+		}
+		total := ev.TotalCommitsCount
+		if total < len(commits) {
+			total = len(commits)
+		}
+		return repoPushMessage(repo, repoURL, user, ev.Ref, compareURL, total, commits)
+	case "merge_request":
+		attrs := &ev.ObjectAttributes
+		var action string
+		switch attrs.Action {
+		case "open":
+			action = "opened"
+		case "reopen":
+			action = "reopened"
+		case "close":
+			action = "closed"
+		case "merge":
+			action = "merged"
+		default:
+			return nil
+		}
+		return repoPullRequestMessage(repo, repoURL, "merge request", "!"+strconv.Itoa(attrs.IID), attrs.Title, attrs.URL, user, action, attrs.Description)
+	case "release":
+		if ev.Action != "create" {
+			return nil
+		}
+		return repoReleaseMessage(repo, repoURL, user, ev.Tag, ev.Name, ev.URL)
+	case "pipeline":
+		attrs := &ev.ObjectAttributes
+		var status string
+		switch attrs.Status {
+		case "success":
+			status = "success"
+		case "failed":
+			status = "failure"
+		case "canceled":
+			status = "cancelled"
+		default:
+			return nil
+		}
+		url := repoURL + "/-/pipelines/" + strconv.Itoa(attrs.ID)
+		return repoCIMessage(repo, repoURL, "pipeline #"+strconv.Itoa(attrs.ID), attrs.Ref, status, url)
+	}
+	return nil
+}
+
+// httpGitLab accepts GitLab webhooks. Deliveries are verified using
+// Server.GitLabToken, and posted to the channel for the project
+func (s *Server) httpGitLab(w http.ResponseWriter, r *http.Request) {
+	if pdebug.Enabled {
+		pdebug.Printf("http: gitlab request...")
+		defer pdebug.Printf("done with gitlab request")
+	}
+
+	if strings.ToLower(r.Method) != "post" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.repoWebhookAllowed(s.GitLabToken) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if s.GitLabToken != "" {
+		token := r.Header.Get(GitLabTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.GitLabToken)) != 1 {
+			if pdebug.Enabled {
+				pdebug.Printf("invalid gitlab token")
+			}
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+
+	var ev gitlabEvent
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRepoHookBodySize)).Decode(&ev); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to decode gitlab event: %s", err)
+		}
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	msg := gitlabMessage(&ev)
+	if msg == nil {
+		ignoreRepoEvent(w)
+		return
+	}
+	s.postRepoMessage(w, ev.Project.PathWithNamespace, msg)
+}
@@ -0,0 +1,235 @@
+package slackgw
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/lestrrat/go-slackgw/slackgwpb"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcServer implements slackgwpb.SlackgwServer on top of Server. Each
+// call is authenticated and scoped like the HTTP endpoint it mirrors
+type grpcServer struct {
+	s *Server
+}
+
+// StartGRPC starts serving the gRPC API (see slackgwpb) on the given address
+func (s *Server) StartGRPC(listen string) error {
+	done := s.done
+	if done == nil {
+		return errors.New("server is not connected or is shutting down")
+	}
+
+	if pdebug.Enabled {
+		pdebug.Printf("Listening for gRPC on %s", listen)
+	}
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+
+	gs := grpc.NewServer()
+	slackgwpb.RegisterSlackgwServer(gs, &grpcServer{s: s})
+	go func() {
+		<-done
+		gs.Stop()
+	}()
+	go gs.Serve(l)
+	return nil
+}
+
+// authenticate reads the key from the metadata named after AuthHeader
+func (g *grpcServer) authenticate(ctx context.Context, endpoint string) (*Credential, error) {
+	s := g.s
+	if s.AuthHeader == "" {
+		return nil, nil
+	}
+
+	var key string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md[strings.ToLower(s.AuthHeader)]; len(v) > 0 {
+			key = v[0]
+		}
+	}
+
+	c, err := s.authenticateToken(key, endpoint)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to authenticate: %s", err)
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "%s", err)
+	}
+	return c, nil
+}
+
+// newMessage authenticates the call, and prepares a message for it
+func (g *grpcServer) newMessage(ctx context.Context, endpoint, channel string) (*Message, error) {
+	cred, err := g.authenticate(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if channel == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "channel must be specified")
+	}
+	if cred != nil && !g.s.allowsChannel(cred, channel) {
+		return nil, status.Errorf(codes.PermissionDenied, "may not post to %s", channel)
+	}
+
+	msg := msgPool.Get().(*Message)
+	msg.Channel = channel
+	if cred != nil {
+		msg.owner = cred.Name
+	}
+	return msg, nil
+}
+
+// send posts msg, either synchronously or as a job. msg is released
+// once it has been sent
+func (g *grpcServer) send(msg *Message, async bool) (*slackgwpb.PostMessageResponse, error) {
+	s := g.s
+	if async {
+		j, err := s.postMessageAsync(msg)
+		if err != nil {
+			releaseMessage(msg)
+			return nil, status.Errorf(codes.Internal, "failed to queue message: %s", err)
+		}
+		return &slackgwpb.PostMessageResponse{JobId: j.ID}, nil
+	}
+	defer releaseMessage(msg)
+
+	if err := s.postMessage(msg); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to post message: %s", err)
+		}
+		if errors.Cause(err) == errMessageKeyNotFound {
+			return nil, status.Errorf(codes.NotFound, "%s", err)
+		}
+		return nil, status.Errorf(codes.Unavailable, "%s", err)
+	}
+
+	return &slackgwpb.PostMessageResponse{
+		Channel:   msg.postedChannel,
+		Ts:        msg.postedTS,
+		Permalink: s.permalink(msg),
+	}, nil
+}
+
+func (g *grpcServer) PostMessage(ctx context.Context, req *slackgwpb.PostMessageRequest) (*slackgwpb.PostMessageResponse, error) {
+	msg, err := g.newMessage(ctx, "/post", req.Channel)
+	if err != nil {
+		return nil, err
+	}
+	msg.Message = req.Text
+	msg.ThreadTimestamp = req.ThreadTs
+	msg.ReplyBroadcast = req.ReplyBroadcast
+	msg.Key = req.Key
+	msg.Params.Username = req.Username
+	msg.Params.IconEmoji = req.IconEmoji
+	msg.Params.IconURL = req.IconUrl
+
+	if err := g.s.verifyThread(msg); err != nil {
+		releaseMessage(msg)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid thread: %s", err)
+	}
+	return g.send(msg, req.Async)
+}
+
+func (g *grpcServer) UpdateMessage(ctx context.Context, req *slackgwpb.UpdateMessageRequest) (*slackgwpb.PostMessageResponse, error) {
+	if req.Ts == "" && req.Key == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "either ts or key must be specified")
+	}
+	msg, err := g.newMessage(ctx, "/update", req.Channel)
+	if err != nil {
+		return nil, err
+	}
+	msg.op = opUpdate
+	msg.Timestamp = req.Ts
+	msg.Key = req.Key
+	msg.Message = req.Text
+	return g.send(msg, req.Async)
+}
+
+func (g *grpcServer) DeleteMessage(ctx context.Context, req *slackgwpb.DeleteMessageRequest) (*slackgwpb.PostMessageResponse, error) {
+	if req.Ts == "" && req.Key == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "either ts or key must be specified")
+	}
+	msg, err := g.newMessage(ctx, "/delete", req.Channel)
+	if err != nil {
+		return nil, err
+	}
+	msg.op = opDelete
+	msg.Timestamp = req.Ts
+	msg.Key = req.Key
+	return g.send(msg, req.Async)
+}
+
+func (g *grpcServer) GetJob(ctx context.Context, req *slackgwpb.GetJobRequest) (*slackgwpb.Job, error) {
+	cred, err := g.authenticate(ctx, "/jobs/"+req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	j, ok := g.s.jobs.get(req.Id)
+	// Clients may only see their own jobs
+	if !ok || (cred != nil && j.owner != cred.Name) {
+		return nil, status.Errorf(codes.NotFound, "no such job")
+	}
+	return &slackgwpb.Job{
+		Id:        j.ID,
+		Status:    j.Status,
+		Error:     j.Error,
+		Channel:   j.Channel,
+		Ts:        j.Timestamp,
+		Permalink: j.Permalink,
+	}, nil
+}
+
+func (g *grpcServer) StreamEvents(req *slackgwpb.StreamEventsRequest, stream slackgwpb.Slackgw_StreamEventsServer) error {
+	cred, err := g.authenticate(stream.Context(), EventStreamEndpoint)
+	if err != nil {
+		return err
+	}
+
+	f, err := newEventFilter(req.Events, nil, "")
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%s", err)
+	}
+
+	s := g.s
+	f.allowed = s.channelScope(cred)
+	sub := s.events.subscribe(s.streamBuffer(), s.streamDropPolicy(), f)
+	defer s.events.unsubscribe(sub)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-sub.gone:
+			return status.Errorf(codes.ResourceExhausted, "too slow, disconnected")
+		case ev := <-sub.ch:
+			if !f.allows(ev) {
+				continue
+			}
+			name := eventName(ev)
+			data, err := json.Marshal(ev.Data)
+			if err != nil {
+				if pdebug.Enabled {
+					pdebug.Printf("failed to encode %s: %s", name, err)
+				}
+				continue
+			}
+			if err := stream.Send(&slackgwpb.Event{Name: name, Type: ev.Type, Data: data}); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package slackgw
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/go-slackgw/slackgwpb"
+	"github.com/nlopes/slack"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPC(t *testing.T) {
+	s0 := New()
+	defer s0.Close()
+	s0.AuthHeader = "X-Slackgw-Key"
+	s0.Credentials = newTestCredentialStore(t)
+	s0.slack = &mockSlackClient{names: map[string]string{"C024BE91L": "deploy", "C024BE91M": "general"}}
+
+	go func() {
+		for msg := range s0.bus {
+			msg.postedChannel = "C12345"
+			msg.postedTS = "1461720000.000002"
+			msg.dst <- nil
+		}
+	}()
+
+	// Find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	if err := s0.StartGRPC(addr); err != nil {
+		t.Fatalf("failed to start gRPC server: %s", err)
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+	client := slackgwpb.NewSlackgwClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	req := &slackgwpb.PostMessageRequest{Channel: "#deploy", Text: "Hello, World!"}
+	if _, err := client.PostMessage(ctx, req); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+	}
+
+	authctx := metadata.NewOutgoingContext(ctx, metadata.Pairs("x-slackgw-key", "deploy-key"))
+	res, err := client.PostMessage(authctx, req)
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	if res.Channel != "C12345" || res.Ts != "1461720000.000002" {
+		t.Errorf("unexpected response: %#v", res)
+	}
+
+	req.Channel = "#general"
+	if _, err := client.PostMessage(authctx, req); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied, got %v", err)
+	}
+
+	stream, err := client.StreamEvents(authctx, &slackgwpb.StreamEventsRequest{Events: []string{"MessageEvent"}})
+	if err != nil {
+		t.Fatalf("failed to stream events: %s", err)
+	}
+
+	// The subscription is set up asynchronously, so keep publishing
+	// until the stream picks an event up. The key is limited to #deploy
+	go func() {
+		for ctx.Err() == nil {
+			s0.events.publish(slack.RTMEvent{Type: "hello", Data: &slack.HelloEvent{}})
+			s0.events.publish(newTestMessageEvent("C024BE91M", "general"))
+			s0.events.publish(newTestMessageEvent("C024BE91L", "deploy"))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	ev, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed to receive event: %s", err)
+	}
+	if ev.Name != "MessageEvent" || ev.Type != "message" || !strings.Contains(string(ev.Data), "C024BE91L") {
+		t.Errorf("unexpected event: %#v", ev)
+	}
+
+	// Keys scoped to the stream endpoint may stream over gRPC too, and
+	// keys scoped to other endpoints may not
+	streamctx := metadata.NewOutgoingContext(ctx, metadata.Pairs("x-slackgw-key", "stream-key"))
+	stream, err = client.StreamEvents(streamctx, &slackgwpb.StreamEventsRequest{Events: []string{"MessageEvent"}})
+	if err != nil {
+		t.Fatalf("failed to stream events: %s", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Errorf("failed to receive event with a scoped key: %s", err)
+	}
+
+	jobsctx := metadata.NewOutgoingContext(ctx, metadata.Pairs("x-slackgw-key", "jobs-key"))
+	stream, err = client.StreamEvents(jobsctx, &slackgwpb.StreamEventsRequest{Events: []string{"MessageEvent"}})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated, got %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package slackgw
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+// HookRoute describes how to turn arbitrary JSON posted to /hook/{Name}
+// into a message. Each of Channel, Text and Color is either a JSONPath
+// expression starting with "$" (e.g. "$.alert.channel"), or a text/template
+// that is executed with the decoded payload (e.g. "{{.host}} is down").
+// Strings without template actions are used as-is
+type HookRoute struct {
+	Name    string `json:"name"`
+	Channel string `json:"channel"`
+	Text    string `json:"text"`
+	Color   string `json:"color"` // if non empty, the text is posted as an attachment in this color
+}
+
+// Hook is a compiled HookRoute
+type Hook struct {
+	name    string
+	channel hookExpr
+	text    hookExpr
+	color   hookExpr
+}
+
+// hookExpr picks a string out of a decoded JSON payload
+type hookExpr interface {
+	eval(interface{}) (string, error)
+}
+
+// jsonPath is a (small) subset of JSONPath: "$" followed by any number
+// of ".name", "['name']" and "[index]" steps
+type jsonPath []interface{}
+
+func parseJSONPath(expr string) (jsonPath, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return nil, errors.Errorf("JSONPath must start with '$': %s", expr)
+	}
+
+	var path jsonPath
+	rest := expr[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			i := strings.IndexAny(rest, ".[")
+			if i == -1 {
+				i = len(rest)
+			}
+			if i == 0 {
+				return nil, errors.Errorf("empty name in JSONPath: %s", expr)
+			}
+			path = append(path, rest[:i])
+			rest = rest[i:]
+		case '[':
+			i := strings.IndexByte(rest, ']')
+			if i == -1 {
+				return nil, errors.Errorf("unterminated '[' in JSONPath: %s", expr)
+			}
+			step := rest[1:i]
+			rest = rest[i+1:]
+			if len(step) >= 2 && (step[0] == '\'' || step[0] == '"') && step[len(step)-1] == step[0] {
+				path = append(path, step[1:len(step)-1])
+				continue
+			}
+			n, err := strconv.Atoi(step)
+			if err != nil {
+				return nil, errors.Errorf("invalid index '%s' in JSONPath: %s", step, expr)
+			}
+			path = append(path, n)
+		default:
+			return nil, errors.Errorf("unexpected '%c' in JSONPath: %s", rest[0], expr)
+		}
+	}
+	return path, nil
+}
+
+func (p jsonPath) eval(v interface{}) (string, error) {
+	for _, step := range p {
+		switch step := step.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return "", nil
+			}
+			v = m[step]
+		case int:
+			l, ok := v.([]interface{})
+			if !ok || step < 0 || step >= len(l) {
+				return "", nil
+			}
+			v = l[step]
+		}
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode value")
+	}
+	return string(buf), nil
+}
+
+type templateExpr struct {
+	*template.Template
+}
+
+func (t templateExpr) eval(v interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, v); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func compileHookExpr(name, expr string) (hookExpr, error) {
+	if strings.HasPrefix(expr, "$") {
+		return parseJSONPath(expr)
+	}
+	// Fail on fields missing from the payload, rather than posting "<no value>"
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return templateExpr{t}, nil
+}
+
+// CompileHook parses the expressions in route
+func CompileHook(route HookRoute) (*Hook, error) {
+	if route.Name == "" || strings.Contains(route.Name, "/") {
+		return nil, errors.Errorf("invalid hook name '%s'", route.Name)
+	}
+	if route.Channel == "" || route.Text == "" {
+		return nil, errors.Errorf("hook '%s' must specify channel and text", route.Name)
+	}
+
+	h := &Hook{name: route.Name}
+	for _, f := range []struct {
+		name string
+		expr string
+		dst  *hookExpr
+	}{
+		{"channel", route.Channel, &h.channel},
+		{"text", route.Text, &h.text},
+		{"color", route.Color, &h.color},
+	} {
+		if f.expr == "" {
+			continue
+		}
+		e, err := compileHookExpr(f.name, f.expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s for hook '%s'", f.name, route.Name)
+		}
+		*f.dst = e
+	}
+	return h, nil
+}
+
+// ReadHooks reads a JSON list of HookRoutes from filename, and compiles
+// them. The result is keyed by the hook name
+func ReadHooks(filename string) (map[string]*Hook, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open hooks")
+	}
+	defer f.Close()
+
+	var routes []HookRoute
+	if err := json.NewDecoder(f).Decode(&routes); err != nil {
+		return nil, errors.Wrap(err, "failed to decode hooks")
+	}
+
+	hooks := make(map[string]*Hook)
+	for _, route := range routes {
+		if _, ok := hooks[route.Name]; ok {
+			return nil, errors.Errorf("duplicate hook '%s'", route.Name)
+		}
+		h, err := CompileHook(route)
+		if err != nil {
+			return nil, err
+		}
+		hooks[route.Name] = h
+	}
+	return hooks, nil
+}
+
+// message builds a message out of payload
+func (h *Hook) message(payload interface{}) (*Message, error) {
+	channel, err := h.channel.eval(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to evaluate channel")
+	}
+	text, err := h.text.eval(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to evaluate text")
+	}
+	var color string
+	if h.color != nil {
+		if color, err = h.color.eval(payload); err != nil {
+			return nil, errors.Wrap(err, "failed to evaluate color")
+		}
+	}
+
+	channel = strings.TrimSpace(channel)
+	if channel == "" {
+		return nil, errors.New("no channel in payload")
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("no text in payload")
+	}
+
+	msg := msgPool.Get().(*Message)
+	msg.Channel = channel
+	if color = strings.TrimSpace(color); color == "" {
+		msg.Message = text
+		return msg, nil
+	}
+	msg.Params.Attachments = []slack.Attachment{{
+		Color:      color,
+		Fallback:   text,
+		Text:       text,
+		MarkdownIn: []string{"text"},
+	}}
+	return msg, nil
+}
+
+// httpHook builds a message out of the JSON posted to /hook/{name}
+// using the hook with that name
+func (s *Server) httpHook(w http.ResponseWriter, r *http.Request) {
+	if pdebug.Enabled {
+		pdebug.Printf("http: hook request...")
+		defer pdebug.Printf("done with hook request")
+	}
+
+	cred, err := s.authenticate(r)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to authenticate: %s", err)
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/hook/"), "/")
+	s.config.RLock()
+	h, ok := s.Hooks[name]
+	s.config.RUnlock()
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if strings.ToLower(r.Method) != "post" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var payload interface{}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&payload); err != nil {
+		http.Error(w, "Failed to parse request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	msg, err := h.message(payload)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("hook '%s': %s", name, err)
+		}
+		http.Error(w, fmt.Sprintf("Hook '%s' failed: %s", name, err), http.StatusBadRequest)
+		return
+	}
+
+	if cred != nil {
+		if !s.allowsChannel(cred, msg.Channel) {
+			releaseMessage(msg)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		msg.owner = cred.Name
+	}
+
+	s.dispatch(w, r, msg)
+}
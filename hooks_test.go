@@ -0,0 +1,82 @@
+package slackgw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	deploy, err := CompileHook(HookRoute{
+		Name:    "deploy",
+		Channel: "$.target.channel",
+		Text:    "{{.app}} {{.version}} deployed by {{.user}}",
+		Color:   `{{if eq .status "ok"}}good{{else}}danger{{end}}`,
+	})
+	if err != nil {
+		t.Fatalf("failed to compile hook: %s", err)
+	}
+	if _, err := CompileHook(HookRoute{Name: "broken", Channel: "$.a[", Text: "x"}); err == nil {
+		t.Errorf("expected invalid JSONPath to be rejected")
+	}
+
+	s0 := New()
+	s0.Hooks = map[string]*Hook{"deploy": deploy}
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	received := make(chan *Message, 1)
+	go func() {
+		for msg := range s0.bus {
+			c := *msg
+			received <- &c
+			msg.dst <- nil
+		}
+	}()
+
+	payload := `{"app":"api","version":"1.2.3","user":"alice","status":"ok","target":{"channel":"#deploys"}}`
+	res, err := http.Post(s.URL+"/hook/deploy", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+
+	msg := <-received
+	if msg.Channel != "#deploys" {
+		t.Errorf("expected channel #deploys, got %s", msg.Channel)
+	}
+	if len(msg.Params.Attachments) != 1 {
+		t.Fatalf("expected an attachment, got %#v", msg.Params.Attachments)
+	}
+	if a := msg.Params.Attachments[0]; a.Color != "good" || a.Text != "api 1.2.3 deployed by alice" {
+		t.Errorf("unexpected attachment: %#v", a)
+	}
+
+	for path, status := range map[string]int{
+		"/hook/deploy":  http.StatusBadRequest, // no channel in payload
+		"/hook/unknown": http.StatusNotFound,
+	} {
+		res, err := http.Post(s.URL+path, "application/json", strings.NewReader(`{"app":"api"}`))
+		if err != nil {
+			t.Fatalf("failed to post: %s", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != status {
+			t.Errorf("%s: expected %d, got %d", path, status, res.StatusCode)
+		}
+	}
+
+	// "user" is missing
+	res, err = http.Post(s.URL+"/hook/deploy", "application/json", strings.NewReader(`{"app":"api","version":"1.2.3","target":{"channel":"#deploys"}}`))
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a payload missing a field, got %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,290 @@
+package slackgw
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nlopes/slack"
+)
+
+// IdempotencyKeyHeader is the header clients use to mark retries of the
+// same request
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyWindow is how long idempotency keys are remembered
+// when Server.IdempotencyWindow is not specified
+const DefaultIdempotencyWindow = time.Hour
+
+// idempotencyEntry holds the response to the first request made with
+// a given idempotency key. ready is closed once the response is known
+type idempotencyEntry struct {
+	ready       chan struct{}
+	fingerprint string // digest of the request, empty if unknown
+	status      int
+	header      http.Header
+	body        []byte
+	expires     time.Time
+}
+
+type idempotencyCache struct {
+	mutex   sync.Mutex
+	entries map[string]*idempotencyEntry
+	expires idempotencyHeap // the resolved entries, by expiration time
+}
+
+type idempotencyExpiry struct {
+	key   string
+	entry *idempotencyEntry
+}
+
+// idempotencyHeap is a container/heap of idempotencyExpiry, soonest to
+// expire first
+type idempotencyHeap []idempotencyExpiry
+
+func (h idempotencyHeap) Len() int { return len(h) }
+func (h idempotencyHeap) Less(i, j int) bool {
+	return h[i].entry.expires.Before(h[j].entry.expires)
+}
+func (h idempotencyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *idempotencyHeap) Push(x interface{}) { *h = append(*h, x.(idempotencyExpiry)) }
+func (h *idempotencyHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// claim looks up key. If nobody has used the key yet (or the previous
+// use failed or expired), a new pending entry is created and true is
+// returned: the caller must then call resolve. Otherwise the existing
+// entry is returned, and the caller should wait for it to become ready
+func (c *idempotencyCache) claim(key, fingerprint string) (*idempotencyEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]*idempotencyEntry)
+	}
+
+	now := time.Now()
+	for len(c.expires) > 0 && now.After(c.expires[0].entry.expires) {
+		x := heap.Pop(&c.expires).(idempotencyExpiry)
+		if c.entries[x.key] == x.entry {
+			delete(c.entries, x.key)
+		}
+	}
+
+	if e, ok := c.entries[key]; ok {
+		return e, false
+	}
+
+	e := &idempotencyEntry{ready: make(chan struct{}), fingerprint: fingerprint}
+	c.entries[key] = e
+	return e, true
+}
+
+// resolve records the response for key. Only successful responses are
+// remembered, so that clients may retry requests that failed
+func (c *idempotencyCache) resolve(key string, status int, header http.Header, body []byte, window time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	select {
+	case <-e.ready:
+		// already resolved
+		return
+	default:
+	}
+
+	if status >= 200 && status < 300 {
+		e.status = status
+		e.header = header
+		e.body = body
+		e.expires = time.Now().Add(window)
+		heap.Push(&c.expires, idempotencyExpiry{key: key, entry: e})
+	} else {
+		delete(c.entries, key)
+	}
+	close(e.ready)
+}
+
+func (e *idempotencyEntry) replay(w http.ResponseWriter) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// responseRecorder writes the response through to the client, while
+// keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (s *Server) idempotencyWindow() time.Duration {
+	if s.IdempotencyWindow > 0 {
+		return s.IdempotencyWindow
+	}
+	return DefaultIdempotencyWindow
+}
+
+// matches reports whether a request with the given fingerprint may be
+// answered with the response to e. Entries for messages replayed from
+// the outbox have no fingerprint, and match anything
+func (e *idempotencyEntry) matches(fingerprint string) bool {
+	return e.fingerprint == "" || e.fingerprint == fingerprint
+}
+
+// requestFingerprint digests the parts of msg that the client sent, so
+// that an idempotency key reused for a different request can be told
+// apart from a retry
+func requestFingerprint(msg *Message) string {
+	buf, err := json.Marshal(struct {
+		Channel         string                      `json:"channel"`
+		Message         string                      `json:"message"`
+		ThreadTimestamp string                      `json:"thread_ts"`
+		ReplyBroadcast  bool                        `json:"reply_broadcast"`
+		Timestamp       string                      `json:"ts"`
+		Key             string                      `json:"key"`
+		PostAt          time.Time                   `json:"post_at"`
+		Delay           Duration                    `json:"delay"`
+		Params          slack.PostMessageParameters `json:"params"`
+		File            *messageFile                `json:"file"`
+	}{
+		Channel:         msg.Channel,
+		Message:         msg.Message,
+		ThreadTimestamp: msg.ThreadTimestamp,
+		ReplyBroadcast:  msg.ReplyBroadcast,
+		Timestamp:       msg.Timestamp,
+		Key:             msg.Key,
+		PostAt:          msg.PostAt,
+		Delay:           msg.Delay,
+		Params:          msg.Params,
+		File:            msg.file,
+	})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyKey namespaces the key sent by the client, so that
+// different clients and endpoints do not step on each other
+func idempotencyKey(owner, path, key string) string {
+	return owner + "\x00" + path + "\x00" + key
+}
+
+// dispatchIdempotent dispatches msg, unless a request with the same
+// idempotency key has already been handled, in which case the original
+// response is sent instead
+func (s *Server) dispatchIdempotent(w http.ResponseWriter, r *http.Request, msg *Message, key string) {
+	key = idempotencyKey(msg.owner, r.URL.Path, key)
+	fingerprint := requestFingerprint(msg)
+	for {
+		e, ok := s.idempotency.claim(key, fingerprint)
+		if ok {
+			break
+		}
+
+		if !e.matches(fingerprint) {
+			releaseMessage(msg)
+			http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+			return
+		}
+
+		// Somebody else got here first. Wait for them to finish
+		<-e.ready
+		if e.status != 0 {
+			if pdebug.Enabled {
+				pdebug.Printf("replaying response for idempotency key")
+			}
+			releaseMessage(msg)
+			e.replay(w)
+			return
+		}
+		// The previous request failed. Try to claim the key again
+	}
+
+	rec := &responseRecorder{ResponseWriter: w}
+	msg.idempotencyKey = key
+	s.dispatchMessage(rec, r, msg)
+
+	header := make(http.Header)
+	for k, v := range rec.Header() {
+		header[k] = v
+	}
+	s.idempotency.resolve(key, rec.status, header, rec.body.Bytes(), s.idempotencyWindow())
+}
+
+// claimReplayed registers the idempotency key of a message replayed
+// from the outbox, so that clients retrying after a restart do not
+// cause the message to be sent again
+func (s *Server) claimReplayed(msg *Message) {
+	if msg.idempotencyKey == "" {
+		return
+	}
+	if _, ok := s.idempotency.claim(msg.idempotencyKey, ""); !ok {
+		msg.idempotencyKey = ""
+	}
+}
+
+// resolveReplayed records the outcome of a message replayed from the
+// outbox under its idempotency key
+func (s *Server) resolveReplayed(msg *Message, err error) {
+	if msg.idempotencyKey == "" {
+		return
+	}
+
+	if err != nil {
+		s.idempotency.resolve(msg.idempotencyKey, http.StatusInternalServerError, nil, nil, 0)
+		return
+	}
+
+	s.resolveReplayedWith(msg, http.StatusOK, &PostResult{
+		Channel:   msg.postedChannel,
+		Timestamp: msg.postedTS,
+		Permalink: s.permalink(msg),
+	})
+}
+
+// resolveReplayedWith records v as the JSON response for the idempotency
+// key of a message replayed from the outbox
+func (s *Server) resolveReplayedWith(msg *Message, status int, v interface{}) {
+	if msg.idempotencyKey == "" {
+		return
+	}
+
+	body, _ := json.Marshal(v)
+	header := http.Header{"Content-Type": {"application/json"}}
+	s.idempotency.resolve(msg.idempotencyKey, status, header, append(body, '\n'), s.idempotencyWindow())
+}
@@ -2,6 +2,9 @@ package slackgw
 
 import (
 	"net/http"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/nlopes/slack"
 )
@@ -53,6 +56,8 @@ const (
 	UnmarshallingErrorEvent
 	UserChangeEvent
 	UserTypingEvent
+	ChannelArchiveEvent
+	MemberJoinedChannelEvent
 	MaxEvent
 )
 
@@ -60,6 +65,11 @@ type SlackClient interface {
 	NewRTM() *slack.RTM
 	AuthTest() (*slack.AuthTestResponse, error)
 	PostMessage(string, string, slack.PostMessageParameters) (string, string, error)
+	SendMessage(string, ...slack.MsgOption) (string, string, string, error)
+	DeleteMessage(string, string) (string, string, error)
+	GetConversationInfo(string, bool) (*slack.Channel, error)
+	GetConversationReplies(*slack.GetConversationRepliesParameters) ([]slack.Message, bool, string, error)
+	UploadFile(slack.FileUploadParameters) (*slack.File, error)
 }
 
 type SlackRTMClient interface {
@@ -68,12 +78,47 @@ type SlackRTMClient interface {
 
 type Server struct {
 	*http.ServeMux
-	AuthHeader string // if non empty, authorize
-	AuthToken  string // XXX temporary. do not rely on this being here
-	bus        chan *Message
-	done       chan struct{}
-	slack      SlackClient // For testing purposes, we use an interface here
-	rtm        *slack.RTM
-	rtmhandler SlackRTMHandler // Handles mesages
-	slackuser  string
+	AuthHeader        string             // if non empty, authorize
+	AuthToken         string             // XXX temporary. do not rely on this being here
+	Credentials       CredentialStore    // if non nil, used instead of AuthToken
+	SignatureMaxAge   time.Duration      // max clock skew for signed requests. If 0, DefaultSignatureMaxAge
+	MaxJobs           int                // number of completed async jobs to remember. If 0, DefaultMaxJobs
+	MessageKeys       MessageKeyStore    // maps caller supplied keys to posted messages
+	Outbox            *Outbox            // if non nil, outgoing messages are persisted here until sent
+	Retry             RetryPolicy        // how to retry failed deliveries
+	ChannelInterval   time.Duration      // minimum interval between messages to the same channel
+	IdempotencyWindow time.Duration      // how long Idempotency-Key headers are remembered. If 0, DefaultIdempotencyWindow
+	WebhookChannel    string             // channel for /services/ payloads that do not specify one
+	AlertTemplate     *template.Template // renders alerts posted to /alertmanager. If nil, NewAlertTemplate()
+	AlertRoutes       []AlertRoute       // routes alerts to channels by their labels
+	AlertChannel      string             // channel for alerts that do not match any route
+	GitHubSecret      string             // secret used to verify /github deliveries
+	GitLabToken       string             // secret token used to verify /gitlab deliveries
+	Hooks             map[string]*Hook   // hooks available under /hook/{name}
+	RepoChannels      map[string]string  // maps "owner/repo", "owner/*" or "*" to the channel for repository events
+	SyslogRules       []SyslogRule       // routes syslog messages to channels
+	SyslogInterval    time.Duration      // interval for collapsing and rate limiting syslog messages. If 0, DefaultSyslogInterval
+	SyslogBurst       int                // max syslog lines sent to a channel per interval. If 0, DefaultSyslogBurst
+	SMTPDomain        string             // domain we accept mail for. If empty, DefaultSMTPDomain
+	SMTPChannels      map[string]string  // maps local parts of addresses to channels. Mail for other addresses is rejected
+	SMTPMaxSize       int64              // max size of mail messages. If 0, DefaultSMTPMaxSize
+	StreamBuffer      int                // events buffered for each /events/stream client. If 0, DefaultStreamBuffer
+	StreamDropPolicy  string             // what to do when a streaming client's buffer is full. If empty, DropNewest
+	Reload            func() error       // called when the process receives SIGHUP. See Reconfigure
+	config            sync.RWMutex       // protects the fields that may be changed by Reconfigure
+	replays           replayGuard
+	jobs              jobStore
+	idempotency       idempotencyCache
+	msgkeyLocks       keyLocks
+	channelIDs        channelIDCache // channel name -> ID, used to pace messages per channel
+	scheduler         scheduler
+	syslog            syslogLimiter
+	events            eventHub
+	bus               chan *Message
+	done              chan struct{}
+	slack             SlackClient // For testing purposes, we use an interface here
+	rtm               *slack.RTM
+	rtmhandler        SlackRTMHandler // Handles mesages
+	slackuser         string
+	teamURL           string
 }
@@ -0,0 +1,181 @@
+package slackgw
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+)
+
+// Job states
+const (
+	JobQueued = "queued"
+	JobSent   = "sent"
+	JobFailed = "failed"
+)
+
+// DefaultMaxJobs is the number of completed jobs kept around when
+// Server.MaxJobs is not specified
+const DefaultMaxJobs = 1000
+
+// Job describes the state of a message posted asynchronously
+type Job struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Channel   string    `json:"channel,omitempty"`
+	Timestamp string    `json:"ts,omitempty"`
+	Permalink string    `json:"permalink,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	owner     string    // name of the client that created this job
+}
+
+// jobStore keeps track of asynchronous jobs. Queued jobs are always kept,
+// but only the last `max` completed jobs are remembered
+type jobStore struct {
+	mutex     sync.Mutex
+	jobs      map[string]*Job
+	completed []string // IDs of completed jobs, oldest first
+	max       int
+}
+
+func newJobID() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// create registers a new queued job. max is the number of completed
+// jobs to keep around. It is set when the store is initialized by the
+// first call, and ignored afterwards
+func (s *jobStore) create(owner string, max int) (*Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	j := &Job{
+		ID:        id,
+		Status:    JobQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+		owner:     owner,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.jobs == nil {
+		s.jobs = make(map[string]*Job)
+		s.max = max
+		if s.max <= 0 {
+			s.max = DefaultMaxJobs
+		}
+	}
+	s.jobs[id] = j
+	c := *j
+	return &c, nil
+}
+
+func (s *jobStore) finish(id, channel, ts, permalink string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+
+	if err != nil {
+		j.Status = JobFailed
+		j.Error = err.Error()
+	} else {
+		j.Status = JobSent
+		j.Channel = channel
+		j.Timestamp = ts
+		j.Permalink = permalink
+	}
+	j.UpdatedAt = time.Now()
+
+	s.completed = append(s.completed, id)
+	for len(s.completed) > s.max {
+		delete(s.jobs, s.completed[0])
+		s.completed = s.completed[1:]
+	}
+}
+
+// get returns a copy of the job, so that the caller may read it
+// without holding the lock
+func (s *jobStore) get(id string) (*Job, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	c := *j
+	return &c, true
+}
+
+func isAsync(r *http.Request) bool {
+	switch strings.ToLower(r.URL.Query().Get("async")) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// postMessageAsync queues msg, and returns immediately with a job
+// that can be queried via /jobs/{id}. msg is released once it has been sent
+func (s *Server) postMessageAsync(msg *Message) (*Job, error) {
+	j, err := s.jobs.create(msg.owner, s.MaxJobs)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer releaseMessage(msg)
+		err := s.postMessage(msg)
+		if pdebug.Enabled {
+			if err != nil {
+				pdebug.Printf("job %s: failed to post message: %s", j.ID, err)
+			} else {
+				pdebug.Printf("job %s: message sent", j.ID)
+			}
+		}
+		s.jobs.finish(j.ID, msg.postedChannel, msg.postedTS, s.permalink(msg), err)
+	}()
+	return j, nil
+}
+
+func (s *Server) httpJobStatus(w http.ResponseWriter, r *http.Request) {
+	cred, err := s.authenticate(r)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to authenticate: %s", err)
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	j, ok := s.jobs.get(id)
+	// Clients may only see their own jobs
+	if !ok || (cred != nil && j.owner != cred.Name) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(j)
+}
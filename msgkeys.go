@@ -0,0 +1,232 @@
+package slackgw
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MessageRef identifies a message that has been posted to slack
+type MessageRef struct {
+	Channel   string `json:"channel"` // channel ID
+	Timestamp string `json:"ts"`
+}
+
+// MessageKeyStore remembers which message belongs to a caller supplied
+// key, so that callers can update or delete messages without keeping
+// track of slack's timestamps themselves
+type MessageKeyStore interface {
+	Get(key string) (MessageRef, bool)
+	Set(key string, ref MessageRef) error
+	Delete(key string) error
+}
+
+var errMessageKeyNotFound = errors.New("message key not found")
+
+// uploads cannot be updated, and slack does not report the timestamp of
+// the message that comes with them, so they cannot have a key either
+var errUploadWithKey = errors.New("key cannot be used with file uploads")
+
+type memoryMessageKeyStore struct {
+	mutex sync.RWMutex
+	refs  map[string]MessageRef
+}
+
+// NewMemoryMessageKeyStore creates a MessageKeyStore that is lost when
+// the process exits
+func NewMemoryMessageKeyStore() MessageKeyStore {
+	return &memoryMessageKeyStore{refs: make(map[string]MessageRef)}
+}
+
+func (s *memoryMessageKeyStore) Get(key string) (MessageRef, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ref, ok := s.refs[key]
+	return ref, ok
+}
+
+func (s *memoryMessageKeyStore) Set(key string, ref MessageRef) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refs[key] = ref
+	return nil
+}
+
+func (s *memoryMessageKeyStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.refs, key)
+	return nil
+}
+
+// FileMessageKeyStore is a MessageKeyStore that is persisted to a JSON
+// file. The whole file is rewritten on every change, so this is only
+// suitable for a modest number of keys
+type FileMessageKeyStore struct {
+	path  string
+	mutex sync.RWMutex
+	refs  map[string]MessageRef
+}
+
+// NewFileMessageKeyStore creates a new FileMessageKeyStore, loading
+// any keys previously saved in the file
+func NewFileMessageKeyStore(path string) (*FileMessageKeyStore, error) {
+	s := &FileMessageKeyStore{
+		path: path,
+		refs: make(map[string]MessageRef),
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return s, nil
+	case err != nil:
+		return nil, errors.Wrap(err, "failed to read message keys")
+	}
+
+	if err := json.Unmarshal(buf, &s.refs); err != nil {
+		return nil, errors.Wrap(err, "failed to decode message keys")
+	}
+	return s, nil
+}
+
+func (s *FileMessageKeyStore) Get(key string) (MessageRef, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	ref, ok := s.refs[key]
+	return ref, ok
+}
+
+func (s *FileMessageKeyStore) Set(key string, ref MessageRef) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.refs[key] = ref
+	return s.save()
+}
+
+func (s *FileMessageKeyStore) Delete(key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.refs[key]; !ok {
+		return nil
+	}
+	delete(s.refs, key)
+	return s.save()
+}
+
+// save writes the keys to a temporary file, and renames it over the
+// original so that we never leave a half written file behind.
+// Must be called with the lock held
+func (s *FileMessageKeyStore) save() error {
+	buf, err := json.Marshal(s.refs)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode message keys")
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), ".slackgw-keys")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return errors.Wrap(err, "failed to write message keys")
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "failed to write message keys")
+	}
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		os.Remove(f.Name())
+		return errors.Wrap(err, "failed to save message keys")
+	}
+	return nil
+}
+
+// keyLocks serializes operations on the same message key, so that two
+// concurrent upserts do not both post a new message
+type keyLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int // number of goroutines holding or waiting for the lock
+}
+
+// lock locks key, and returns the function that unlocks it
+func (l *keyLocks) lock(key string) func() {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyLock{}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mutex.Unlock()
+
+	kl.Lock()
+	return func() {
+		kl.Unlock()
+
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		if kl.refs--; kl.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// messageKey returns the key under which msg is stored. Keys are
+// namespaced by client, so that clients cannot touch each other's messages
+func messageKey(msg *Message) string {
+	if msg.owner == "" {
+		return msg.Key
+	}
+	return msg.owner + "/" + msg.Key
+}
+
+// resolveMessageKey looks up the message referred to by msg.Key. If it
+// exists, msg is turned into an update (or delete) of that message.
+// Otherwise posts proceed as usual, while updates and deletes fail
+func (s *Server) resolveMessageKey(msg *Message) error {
+	if msg.op == opUpload {
+		return errUploadWithKey
+	}
+
+	ref, ok := s.MessageKeys.Get(messageKey(msg))
+	if !ok {
+		if msg.op == opPost {
+			return nil
+		}
+		if msg.Timestamp == "" {
+			return errMessageKeyNotFound
+		}
+		return nil
+	}
+
+	if msg.op == opPost {
+		msg.op = opUpdate
+	}
+	msg.Channel = ref.Channel
+	msg.Timestamp = ref.Timestamp
+	return nil
+}
+
+// recordMessageKey remembers (or forgets) the message associated with
+// msg.Key, after it has been sent
+func (s *Server) recordMessageKey(msg *Message) error {
+	key := messageKey(msg)
+	if msg.op == opDelete {
+		return s.MessageKeys.Delete(key)
+	}
+	return s.MessageKeys.Set(key, MessageRef{Channel: msg.postedChannel, Timestamp: msg.postedTS})
+}
@@ -0,0 +1,382 @@
+package slackgw
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+)
+
+// DefaultMaxSegmentSize is the size at which outbox segments are rotated
+// when Outbox.MaxSegmentSize is not specified
+const DefaultMaxSegmentSize = 4 * 1024 * 1024
+
+// MaxOutboxRecordSize is the largest message (including any file to
+// upload) that can be written to the outbox
+const MaxOutboxRecordSize = 16 * 1024 * 1024
+
+const (
+	segmentPrefix = "outbox-"
+	segmentSuffix = ".log"
+)
+
+// Outbox is a write-ahead log of outgoing messages. Messages are appended
+// before they are handed to the sender, and acknowledged once slack has
+// responded. Messages that were never acknowledged (e.g. because the
+// process crashed) are replayed the next time the outbox is opened, so
+// delivery is at-least-once.
+//
+// The log is split into append-only segment files in a directory. A
+// segment is removed once all messages it and the segments before it
+// contain have been acknowledged. Acknowledgements may be written to a
+// later segment than the message itself, so segments must be removed
+// oldest first
+type Outbox struct {
+	MaxSegmentSize int64
+
+	dir         string
+	mutex       sync.Mutex
+	nextID      uint64
+	segment     *os.File
+	segmentID   uint64
+	segmentSize int64
+	segmentIDs  []uint64          // existing segments, oldest first
+	records     map[uint64]uint64 // unacknowledged record ID -> segment ID
+	live        map[uint64]int    // segment ID -> number of unacknowledged records
+	pending     []*Message        // messages to be replayed
+}
+
+type outboxRecord struct {
+	ID             uint64       `json:"id"`
+	Ack            bool         `json:"ack,omitempty"`
+	Op             int          `json:"op,omitempty"`
+	Owner          string       `json:"owner,omitempty"`
+	IdempotencyKey string       `json:"idempotency_key,omitempty"`
+	File           *messageFile `json:"file,omitempty"`
+	Message        *Message     `json:"message,omitempty"`
+}
+
+func segmentName(id uint64) string {
+	return fmt.Sprintf("%s%020d%s", segmentPrefix, id, segmentSuffix)
+}
+
+// OpenOutbox opens the outbox in dir, creating it if necessary, and
+// loads the messages that have not been acknowledged yet.
+func OpenOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create outbox directory")
+	}
+
+	o := &Outbox{
+		dir:     dir,
+		nextID:  1,
+		records: make(map[uint64]uint64),
+		live:    make(map[uint64]int),
+	}
+
+	ids, err := o.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make(map[uint64]*Message)
+	for _, id := range ids {
+		if err := o.load(id, pending); err != nil {
+			return nil, err
+		}
+	}
+
+	// Replay in the order the messages were written
+	rids := make([]uint64, 0, len(pending))
+	for rid := range pending {
+		rids = append(rids, rid)
+	}
+	sort.Sort(uint64Slice(rids))
+	for _, rid := range rids {
+		o.pending = append(o.pending, pending[rid])
+		o.live[o.records[rid]]++
+	}
+
+	o.segmentIDs = ids
+	var last uint64
+	if len(ids) > 0 {
+		last = ids[len(ids)-1]
+	}
+	if err := o.rotate(last + 1); err != nil {
+		return nil, err
+	}
+
+	if pdebug.Enabled {
+		pdebug.Printf("outbox: %d pending messages in %s", len(o.pending), dir)
+	}
+	return o, nil
+}
+
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// segments returns the IDs of the existing segments, oldest first
+func (o *Outbox) segments() ([]uint64, error) {
+	entries, err := ioutil.ReadDir(o.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read outbox directory")
+	}
+
+	var ids []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		var id uint64
+		if _, err := fmt.Sscanf(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), "%d", &id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Sort(uint64Slice(ids))
+	return ids, nil
+}
+
+// load reads the records in segment id. Acknowledgements may live in a
+// later segment than the message they refer to, so pending is shared
+// across all segments
+func (o *Outbox) load(id uint64, pending map[uint64]*Message) error {
+	f, err := os.Open(filepath.Join(o.dir, segmentName(id)))
+	if err != nil {
+		return errors.Wrap(err, "failed to open outbox segment")
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), MaxOutboxRecordSize)
+	for scanner.Scan() {
+		var rec outboxRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// Most likely a partial write from a crash. Nothing after
+			// this point in the segment can be trusted
+			if pdebug.Enabled {
+				pdebug.Printf("outbox: corrupt record in segment %d: %s", id, err)
+			}
+			break
+		}
+
+		if rec.ID >= o.nextID {
+			o.nextID = rec.ID + 1
+		}
+
+		if rec.Ack {
+			delete(pending, rec.ID)
+			delete(o.records, rec.ID)
+			continue
+		}
+
+		if rec.Message == nil {
+			continue
+		}
+		msg := rec.Message
+		msg.op = rec.Op
+		msg.owner = rec.Owner
+		msg.idempotencyKey = rec.IdempotencyKey
+		msg.file = rec.File
+		msg.outboxID = rec.ID
+		pending[rec.ID] = msg
+		o.records[rec.ID] = id
+	}
+	return errors.Wrap(scanner.Err(), "failed to read outbox segment")
+}
+
+// rotate starts writing to a new segment. Must be called with the lock held
+func (o *Outbox) rotate(id uint64) error {
+	f, err := os.OpenFile(filepath.Join(o.dir, segmentName(id)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to create outbox segment")
+	}
+
+	if o.segment != nil {
+		o.segment.Close()
+	}
+	o.segment = f
+	o.segmentID = id
+	o.segmentSize = 0
+	o.segmentIDs = append(o.segmentIDs, id)
+	o.compact()
+	return nil
+}
+
+// compact removes old segments that no longer contain unacknowledged
+// messages. Must be called with the lock held
+func (o *Outbox) compact() {
+	for len(o.segmentIDs) > 0 {
+		id := o.segmentIDs[0]
+		if id == o.segmentID || o.live[id] > 0 {
+			return
+		}
+		if pdebug.Enabled {
+			pdebug.Printf("outbox: removing segment %d", id)
+		}
+		os.Remove(filepath.Join(o.dir, segmentName(id)))
+		delete(o.live, id)
+		o.segmentIDs = o.segmentIDs[1:]
+	}
+}
+
+// write appends a record to the current segment, and syncs it to disk.
+// Must be called with the lock held
+func (o *Outbox) write(rec *outboxRecord) error {
+	max := o.MaxSegmentSize
+	if max <= 0 {
+		max = DefaultMaxSegmentSize
+	}
+	if o.segmentSize >= max {
+		if err := o.rotate(o.segmentID + 1); err != nil {
+			return err
+		}
+	}
+
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode outbox record")
+	}
+	// Larger records could not be read back
+	if len(buf) >= MaxOutboxRecordSize {
+		return errors.Errorf("message of %d bytes exceeds the outbox limit of %d bytes", len(buf), MaxOutboxRecordSize)
+	}
+	buf = append(buf, '\n')
+
+	n, err := o.segment.Write(buf)
+	o.segmentSize += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "failed to write outbox record")
+	}
+	return errors.Wrap(o.segment.Sync(), "failed to sync outbox segment")
+}
+
+// Pending returns the messages that were not acknowledged the last time
+// the outbox was used. It only returns them once
+func (o *Outbox) Pending() []*Message {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	pending := o.pending
+	o.pending = nil
+	return pending
+}
+
+// Append durably records msg. It must be followed by a call to Ack once
+// the message has been sent
+func (o *Outbox) Append(msg *Message) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.segment == nil {
+		return errors.New("outbox is closed")
+	}
+
+	id := o.nextID
+	rec := outboxRecord{
+		ID:             id,
+		Op:             msg.op,
+		Owner:          msg.owner,
+		IdempotencyKey: msg.idempotencyKey,
+		File:           msg.file,
+		Message:        msg,
+	}
+	if err := o.write(&rec); err != nil {
+		return err
+	}
+
+	o.nextID++
+	msg.outboxID = id
+	o.records[id] = o.segmentID
+	o.live[o.segmentID]++
+	return nil
+}
+
+// Ack marks msg as sent, so that it will not be replayed
+func (o *Outbox) Ack(msg *Message) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.segment == nil {
+		return errors.New("outbox is closed")
+	}
+
+	id := msg.outboxID
+	sid, ok := o.records[id]
+	if !ok {
+		return nil
+	}
+
+	if err := o.write(&outboxRecord{ID: id, Ack: true}); err != nil {
+		return err
+	}
+	delete(o.records, id)
+
+	o.live[sid]--
+	o.compact()
+	return nil
+}
+
+// Close closes the current segment
+func (o *Outbox) Close() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.segment == nil {
+		return nil
+	}
+	err := o.segment.Close()
+	o.segment = nil
+	return err
+}
+
+// replayOutbox hands messages left over from a previous run to the sender
+func (s *Server) replayOutbox() {
+	done := s.done
+	if done == nil {
+		return
+	}
+
+	pending := s.Outbox.Pending()
+	if pdebug.Enabled {
+		pdebug.Printf("Replaying %d messages from outbox", len(pending))
+	}
+	now := time.Now()
+	for _, msg := range pending {
+		s.claimReplayed(msg)
+
+		// Scheduled messages that are not yet due go back to the scheduler
+		if msg.isScheduled(now) {
+			info, err := s.scheduleMessage(msg)
+			if err != nil {
+				if pdebug.Enabled {
+					pdebug.Printf("failed to reschedule message: %s", err)
+				}
+				s.resolveReplayed(msg, err)
+				continue
+			}
+			s.resolveReplayedWith(msg, http.StatusAccepted, info)
+			continue
+		}
+
+		select {
+		case <-done:
+			return
+		case s.bus <- msg:
+		}
+	}
+}
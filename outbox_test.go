@@ -0,0 +1,270 @@
+package slackgw
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackgw-outbox")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("failed to open outbox: %s", err)
+	}
+	// Force a rotation on every write, so that acknowledgements end up
+	// in different segments than the messages they refer to
+	o.MaxSegmentSize = 1
+
+	var msgs []*Message
+	for _, text := range []string{"one", "two", "three", "four"} {
+		msg := &Message{Channel: "#test", Message: text, Key: text}
+		if err := o.Append(msg); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+		msgs = append(msgs, msg)
+	}
+	msgs[1].op = opUpdate // not persisted, since it was set after Append
+
+	for _, i := range []int{0, 2} {
+		if err := o.Ack(msgs[i]); err != nil {
+			t.Fatalf("failed to ack: %s", err)
+		}
+	}
+	o.Close()
+
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen outbox: %s", err)
+	}
+	defer o.Close()
+
+	pending := o.Pending()
+	if len(pending) != 2 {
+		t.Fatalf("expected 2 pending messages, got %d", len(pending))
+	}
+	for i, text := range []string{"two", "four"} {
+		if pending[i].Message != text || pending[i].Key != text {
+			t.Errorf("expected '%s', got %#v", text, pending[i])
+		}
+		if pending[i].op != opPost {
+			t.Errorf("expected op to be restored as post, got %d", pending[i].op)
+		}
+	}
+
+	for _, msg := range pending {
+		if err := o.Ack(msg); err != nil {
+			t.Fatalf("failed to ack: %s", err)
+		}
+	}
+
+	// Everything has been acknowledged, so only the current segment remains
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected a single segment, got %d", len(entries))
+	}
+}
+
+func TestOutboxAck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackgw-outbox")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("failed to open outbox: %s", err)
+	}
+
+	s := New()
+	defer s.Close()
+	s.Outbox = o
+	s.Retry = RetryPolicy{MaxAttempts: 1}
+
+	for _, test := range []struct {
+		text string
+		err  error
+	}{
+		{"sent", nil},
+		{"transient", errors.New("service_unavailable")},
+		{"permanent", errors.New("channel_not_found")},
+	} {
+		msg := &Message{Channel: "#test", Message: test.text}
+		if err := o.Append(msg); err != nil {
+			t.Fatalf("failed to append: %s", err)
+		}
+		if err := s.sendMessage(&mockSlackClient{postErr: test.err}, msg, nil); err != test.err {
+			t.Errorf("'%s': expected error %v, got %v", test.text, test.err, err)
+		}
+	}
+	o.Close()
+
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen outbox: %s", err)
+	}
+	defer o.Close()
+
+	// Only the message that may still go through is left to be replayed
+	pending := o.Pending()
+	if len(pending) != 1 || pending[0].Message != "transient" {
+		t.Errorf("expected only the transient failure to be pending, got %#v", pending)
+	}
+}
+
+func TestOutboxTransientFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackgw-outbox")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("failed to open outbox: %s", err)
+	}
+
+	s0 := New()
+	s0.Outbox = o
+	s0.Retry = RetryPolicy{MaxAttempts: 1}
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	client := &flakySlackClient{failures: []error{errors.New("service_unavailable")}}
+	var posted int32
+	go func() {
+		for msg := range s0.bus {
+			err := s0.sendMessage(client, msg, nil)
+			if err == nil {
+				atomic.AddInt32(&posted, 1)
+			}
+			msg.dst <- err
+		}
+	}()
+
+	// The client sees the failure, and retries by itself
+	for _, expected := range []int{http.StatusInternalServerError, http.StatusOK} {
+		v := url.Values{"channel": {"#test"}, "message": {"once"}}
+		req, _ := http.NewRequest("POST", s.URL+"/post", strings.NewReader(v.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(IdempotencyKeyHeader, "once-1")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to post: %s", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != expected {
+			t.Errorf("expected status %d, got %d", expected, res.StatusCode)
+		}
+	}
+	o.Close()
+
+	// so the failed attempt must not be replayed on the next start
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen outbox: %s", err)
+	}
+	defer o.Close()
+	if pending := o.Pending(); len(pending) != 0 {
+		t.Errorf("expected no pending messages, got %#v", pending)
+	}
+	if n := atomic.LoadInt32(&posted); n != 1 {
+		t.Errorf("expected the message to be posted once, got %d", n)
+	}
+}
+
+func TestOutboxQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackgw-outbox")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("failed to open outbox: %s", err)
+	}
+	defer o.Close()
+
+	s0 := New()
+	s0.Outbox = o
+	s0.bus = make(chan *Message) // nobody is listening yet, i.e. the sender is busy
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	res, err := http.PostForm(s.URL+"/post", url.Values{"channel": {"#test"}, "message": {"later"}})
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted || string(body) != "Queued" {
+		t.Errorf("expected 202 Queued, got %d %s", res.StatusCode, body)
+	}
+
+	// The message is sent once the sender gets to it
+	select {
+	case msg := <-s0.bus:
+		if msg.Message != "later" {
+			t.Errorf("expected 'later', got '%s'", msg.Message)
+		}
+		msg.dst <- nil
+	case <-time.After(time.Second):
+		t.Errorf("timed out waiting for queued message")
+	}
+}
+
+func TestOutboxFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slackgw-outbox")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	o, err := OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("failed to open outbox: %s", err)
+	}
+
+	content := []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0xff, 0xfe}
+	msg := &Message{Channel: "#test", op: opUpload, file: &messageFile{Filename: "x.png", Content: content}}
+	if err := o.Append(msg); err != nil {
+		t.Fatalf("failed to append: %s", err)
+	}
+
+	huge := &Message{Channel: "#test", op: opUpload, file: &messageFile{Filename: "huge.bin", Content: make([]byte, MaxOutboxRecordSize)}}
+	if err := o.Append(huge); err == nil {
+		t.Errorf("expected a message larger than the outbox limit to be rejected")
+	}
+	o.Close()
+
+	o, err = OpenOutbox(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen outbox: %s", err)
+	}
+	defer o.Close()
+
+	pending := o.Pending()
+	if len(pending) != 1 || pending[0].file == nil {
+		t.Fatalf("expected the file to be pending, got %#v", pending)
+	}
+	if got := pending[0].file.Content; string(got) != string(content) {
+		t.Errorf("binary content was not preserved: %v", got)
+	}
+}
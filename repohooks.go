@@ -0,0 +1,193 @@
+package slackgw
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+// maximum number of commits listed for a push
+const maxPushCommits = 10
+
+// max size of the body of GitHub and GitLab webhooks. GitHub caps its
+// payloads at 25MB
+const maxRepoHookBodySize = 25 << 20
+
+// repoCommit is a commit in a push event, common to GitHub and GitLab
+type repoCommit struct {
+	ID      string
+	Message string
+	URL     string
+	Author  string
+}
+
+// ReadRepoChannels reads a JSON object mapping repositories to channels
+// from filename. See Server.RepoChannels
+func ReadRepoChannels(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open repository channels")
+	}
+	defer f.Close()
+
+	var channels map[string]string
+	if err := json.NewDecoder(f).Decode(&channels); err != nil {
+		return nil, errors.Wrap(err, "failed to decode repository channels")
+	}
+	return channels, nil
+}
+
+// repoChannel returns the channel for events from repo ("owner/name").
+// An exact match is preferred over "owner/*", which is preferred over "*"
+func (s *Server) repoChannel(repo string) string {
+	s.config.RLock()
+	defer s.config.RUnlock()
+
+	if ch, ok := s.RepoChannels[repo]; ok {
+		return ch
+	}
+	if i := strings.LastIndexByte(repo, '/'); i > 0 {
+		if ch, ok := s.RepoChannels[repo[:i]+"/*"]; ok {
+			return ch
+		}
+	}
+	return s.RepoChannels["*"]
+}
+
+// repoWebhookAllowed decides what to do with requests to a repository
+// webhook endpoint that has no secret configured: they are only accepted
+// if the server does not require authentication either
+func (s *Server) repoWebhookAllowed(secret string) bool {
+	return secret != "" || s.AuthHeader == ""
+}
+
+// postRepoMessage sends msg to the channel for repo, and writes the response
+func (s *Server) postRepoMessage(w http.ResponseWriter, repo string, msg *Message) {
+	defer releaseMessage(msg)
+
+	msg.Channel = s.repoChannel(repo)
+	if msg.Channel == "" {
+		http.Error(w, "no channel for repository "+repo, http.StatusNotFound)
+		return
+	}
+
+	if err := s.postMessage(msg); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to post event from '%s': %s", repo, err)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Sent"))
+}
+
+// ignoreRepoEvent responds to events that we do not post
+func ignoreRepoEvent(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Ignored"))
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func newRepoMessage(repo, repoURL, text string) *Message {
+	msg := msgPool.Get().(*Message)
+	msg.Message = "[" + slackLink(repoURL, repo) + "] " + text
+	return msg
+}
+
+func repoPushMessage(repo, repoURL, user, ref, compareURL string, total int, commits []repoCommit) *Message {
+	branch := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+	noun := "commits"
+	if total == 1 {
+		noun = "commit"
+	}
+	msg := newRepoMessage(repo, repoURL, fmt.Sprintf("%s pushed %s to `%s`", escapeSlack(user), slackLink(compareURL, fmt.Sprintf("%d %s", total, noun)), escapeSlack(branch)))
+
+	if len(commits) == 0 {
+		return msg
+	}
+	if len(commits) > maxPushCommits {
+		commits = commits[len(commits)-maxPushCommits:]
+	}
+
+	var lines []string
+	for _, c := range commits {
+		lines = append(lines, fmt.Sprintf("%s %s - %s", slackLink(c.URL, "`"+shortSHA(c.ID)+"`"), escapeSlack(firstLine(c.Message)), escapeSlack(c.Author)))
+	}
+	msg.Params.Attachments = []slack.Attachment{{
+		Color:      "#4078c0",
+		Fallback:   msg.Message,
+		Text:       strings.Join(lines, "\n"),
+		MarkdownIn: []string{"text"},
+	}}
+	return msg
+}
+
+// repoPullRequestMessage describes a pull request (GitHub) or merge request
+// (GitLab). ref is the reference to it, e.g. "#12" or "!12"
+func repoPullRequestMessage(repo, repoURL, kind, ref, title, url, user, action, body string) *Message {
+	color := "good"
+	switch action {
+	case "closed":
+		color = "danger"
+	case "merged":
+		color = "#6f42c1"
+	}
+
+	msg := newRepoMessage(repo, repoURL, fmt.Sprintf("%s %s %s %s", escapeSlack(user), action, kind, ref))
+	msg.Params.Attachments = []slack.Attachment{{
+		Color:     color,
+		Fallback:  msg.Message + ": " + escapeSlack(title),
+		Title:     ref + " " + escapeSlack(title),
+		TitleLink: url,
+		Text:      escapeSlack(body),
+	}}
+	return msg
+}
+
+func repoReleaseMessage(repo, repoURL, user, tag, name, url string) *Message {
+	if name == "" {
+		name = tag
+	}
+	return newRepoMessage(repo, repoURL, fmt.Sprintf("%s released %s (`%s`)", escapeSlack(user), slackLink(url, name), escapeSlack(tag)))
+}
+
+// repoCIMessage describes the outcome of a CI run. status is one of
+// "success", "failure" or "cancelled"
+func repoCIMessage(repo, repoURL, name, ref, status, url string) *Message {
+	color := "warning"
+	switch status {
+	case "success":
+		color = "good"
+	case "failure":
+		color = "danger"
+	}
+
+	msg := newRepoMessage(repo, repoURL, fmt.Sprintf("%s on `%s`: %s", slackLink(url, name), escapeSlack(ref), status))
+	msg.Params.Attachments = []slack.Attachment{{
+		Color:    color,
+		Fallback: msg.Message,
+		Text:     fmt.Sprintf("%s %s", escapeSlack(name), status),
+	}}
+	return msg
+}
@@ -0,0 +1,106 @@
+package slackgw
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRepoWebhooks(t *testing.T) {
+	s0 := New()
+	s0.GitHubSecret = "gh-secret"
+	s0.GitLabToken = "gl-token"
+	s0.RepoChannels = map[string]string{
+		"acme/api": "#api",
+		"acme/*":   "#acme",
+	}
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	received := make(chan *Message, 1)
+	go func() {
+		for msg := range s0.bus {
+			c := *msg
+			received <- &c
+			msg.dst <- nil
+		}
+	}()
+
+	github := func(event, body, secret string) int {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		req, _ := http.NewRequest("POST", s.URL+"/github", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(GitHubEventHeader, event)
+		req.Header.Set(GitHubSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to post: %s", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	pr := `{"action":"closed","sender":{"login":"alice"},"repository":{"full_name":"acme/api","html_url":"https://github.com/acme/api"},"pull_request":{"number":12,"title":"Fix things","html_url":"https://github.com/acme/api/pull/12","merged":true}}`
+	if code := github("pull_request", pr, "wrong-secret"); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a bad signature, got %d", code)
+	}
+	if code := github("pull_request", pr, "gh-secret"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	msg := <-received
+	if msg.Channel != "#api" || !strings.Contains(msg.Message, "alice merged pull request #12") {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+	if len(msg.Params.Attachments) != 1 || msg.Params.Attachments[0].TitleLink != "https://github.com/acme/api/pull/12" {
+		t.Errorf("unexpected attachments: %#v", msg.Params.Attachments)
+	}
+
+	// Text from the payload cannot ping the channel
+	pr = strings.Replace(pr, `"Fix things"`, `"<!channel> Fix & ship"`, 1)
+	if code := github("pull_request", pr, "gh-secret"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	msg = <-received
+	if title := msg.Params.Attachments[0].Title; title != "#12 &lt;!channel&gt; Fix &amp; ship" {
+		t.Errorf("unexpected title '%s'", title)
+	}
+
+	gitlab := func(body, token string) int {
+		req, _ := http.NewRequest("POST", s.URL+"/gitlab", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(GitLabTokenHeader, token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to post: %s", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	pipeline := `{"object_kind":"pipeline","user":{"username":"bob"},"project":{"path_with_namespace":"acme/web","web_url":"https://gitlab.com/acme/web"},"object_attributes":{"id":42,"ref":"main","status":"failed"}}`
+	if code := gitlab(pipeline, "wrong-token"); code != http.StatusForbidden {
+		t.Errorf("expected 403 for a bad token, got %d", code)
+	}
+	if code := gitlab(pipeline, "gl-token"); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	msg = <-received
+	if msg.Channel != "#acme" || !strings.Contains(msg.Message, "pipeline #42") || msg.Params.Attachments[0].Color != "danger" {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+
+	running := strings.Replace(pipeline, `"failed"`, `"running"`, 1)
+	if code := gitlab(running, "gl-token"); code != http.StatusOK {
+		t.Errorf("expected 200 for ignored events, got %d", code)
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("running pipelines should not be posted: %#v", msg)
+	default:
+	}
+}
@@ -0,0 +1,112 @@
+package slackgw
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy controls how failed deliveries to slack are retried
+type RetryPolicy struct {
+	MaxAttempts    int           // total number of attempts, including the first. 1 or less disables retries
+	InitialBackoff time.Duration // wait before the first retry. Doubles on every retry
+	MaxBackoff     time.Duration // upper bound for the wait between retries
+	Jitter         float64       // randomize waits by up to this fraction (0.0 - 1.0)
+}
+
+// DefaultRetryPolicy is the RetryPolicy used by servers created via New()
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+	Jitter:         0.2,
+}
+
+// DefaultChannelInterval is the minimum interval between messages sent
+// to the same channel. Slack allows about one message per second per channel
+const DefaultChannelInterval = time.Second
+
+// Backoff returns how long to wait before retry number `retry` (starting at 1)
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < retry && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		delta := float64(d) * p.Jitter
+		d = time.Duration(float64(d) - delta + rand.Float64()*2*delta)
+	}
+	return d
+}
+
+// slack error codes that indicate a problem on slack's side
+var transientSlackErrors = map[string]struct{}{
+	"internal_error":      struct{}{},
+	"fatal_error":         struct{}{},
+	"service_unavailable": struct{}{},
+	"request_timeout":     struct{}{},
+	"ratelimited":         struct{}{},
+	"rate_limited":        struct{}{},
+}
+
+// retryAfter determines if err is worth retrying. If slack told us how
+// long to wait, that duration is returned as well
+func retryAfter(err error) (time.Duration, bool) {
+	switch e := errors.Cause(err).(type) {
+	case *slack.RateLimitedError:
+		return e.RetryAfter, true
+	case net.Error:
+		return 0, true
+	}
+
+	msg := err.Error()
+	if _, ok := transientSlackErrors[msg]; ok {
+		return 0, true
+	}
+
+	// Non-200 responses are reported using the HTTP status line,
+	// e.g. "503 Service Unavailable"
+	if len(msg) >= 3 && msg[0] == '5' && strings.IndexFunc(msg[:3], func(r rune) bool { return r < '0' || r > '9' }) == -1 {
+		return 0, true
+	}
+	return 0, false
+}
+
+// deliverWithRetry delivers msg to slack, retrying transient failures
+// according to the server's RetryPolicy. Gives up early if done is closed
+func (s *Server) deliverWithRetry(client SlackClient, msg *Message, done <-chan struct{}) (string, string, error) {
+	policy := s.Retry
+	for attempt := 1; ; attempt++ {
+		channel, ts, err := deliver(client, msg)
+		if err == nil || attempt >= policy.MaxAttempts {
+			return channel, ts, err
+		}
+
+		wait, ok := retryAfter(err)
+		if !ok {
+			return channel, ts, err
+		}
+		if backoff := policy.Backoff(attempt); wait < backoff {
+			wait = backoff
+		}
+
+		if pdebug.Enabled {
+			pdebug.Printf("attempt %d to send to '%s' failed (%s), retrying in %s", attempt, msg.Channel, err, wait)
+		}
+
+		select {
+		case <-done:
+			return channel, ts, errors.Wrap(err, "server is shutting down")
+		case <-time.After(wait):
+		}
+	}
+}
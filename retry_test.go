@@ -0,0 +1,65 @@
+package slackgw
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+type flakySlackClient struct {
+	mockSlackClient
+	failures []error // returned in order by PostMessage, before succeeding
+	attempts int
+}
+
+func (c *flakySlackClient) PostMessage(channel, text string, params slack.PostMessageParameters) (string, string, error) {
+	c.attempts++
+	if len(c.failures) > 0 {
+		err := c.failures[0]
+		c.failures = c.failures[1:]
+		return "", "", err
+	}
+	return c.mockSlackClient.PostMessage(channel, text, params)
+}
+
+func TestDeliverWithRetry(t *testing.T) {
+	s := New()
+	s.Retry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		failures []error
+		attempts int
+		success  bool
+	}{
+		{"rate limited", []error{&slack.RateLimitedError{RetryAfter: time.Millisecond}}, 2, true},
+		{"server error", []error{errors.New("503 Service Unavailable"), errors.New("internal_error")}, 3, true},
+		{"gave up", []error{errors.New("internal_error"), errors.New("internal_error"), errors.New("internal_error")}, 3, false},
+		{"permanent error", []error{errors.New("channel_not_found")}, 1, false},
+	}
+
+	for _, test := range tests {
+		client := &flakySlackClient{failures: test.failures}
+		_, _, err := s.deliverWithRetry(client, &Message{Channel: "#test"}, nil)
+		if (err == nil) != test.success {
+			t.Errorf("%s: unexpected result: %v", test.name, err)
+		}
+		if client.attempts != test.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", test.name, test.attempts, client.attempts)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+	for retry, expected := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if retry == 0 {
+			continue
+		}
+		if d := p.Backoff(retry); d != expected {
+			t.Errorf("retry %d: expected %s, got %s", retry, expected, d)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package slackgw
 
 import (
+	"strings"
+
 	"github.com/lestrrat/go-pdebug"
 	"github.com/nlopes/slack"
 )
@@ -26,6 +28,10 @@ func (s *Server) handleRTM() {
 	for loop := true; loop; {
 		select {
 		case ev := <-rtm.IncomingEvents:
+			s.events.publish(ev)
+			if hdl == nil {
+				continue
+			}
 			if err := hdl.Handle(&RTMCtx{UserID: s.slackuser, RTM: rtm, Event: ev}); err != nil {
 				if pdebug.Enabled {
 					pdebug.Printf("SlackRTMHandler: %s", err)
@@ -37,3 +43,26 @@ func (s *Server) handleRTM() {
 		}
 	}
 }
+
+// AddressedTo checks if text starts with a mention of userID (e.g.
+// "<@U024BE7LH> deploy", or "<@U024BE7LH|bot>: deploy"), and returns
+// the rest of the text
+func AddressedTo(text, userID string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "<@") {
+		return "", false
+	}
+	end := strings.IndexByte(text, '>')
+	if end == -1 {
+		return "", false
+	}
+	id := text[2:end]
+	if i := strings.IndexByte(id, '|'); i != -1 {
+		id = id[:i]
+	}
+	if id != userID {
+		return "", false
+	}
+	rest := strings.TrimPrefix(text[end+1:], ":")
+	return strings.TrimSpace(rest), true
+}
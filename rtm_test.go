@@ -0,0 +1,24 @@
+package slackgw
+
+import "testing"
+
+func TestAddressedTo(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		rest string
+		ok   bool
+	}{
+		{"<@U12345> deploy api", "deploy api", true},
+		{"<@U12345>: deploy", "deploy", true},
+		{"  <@U12345|bot>:  deploy", "deploy", true},
+		{"<@U12345>", "", true},
+		{"<@U99999> deploy", "", false},
+		{"deploy <@U12345>", "", false},
+		{"<@U12345 deploy", "", false},
+	} {
+		rest, ok := AddressedTo(test.text, "U12345")
+		if rest != test.rest || ok != test.ok {
+			t.Errorf("'%s': expected ('%s', %t), got ('%s', %t)", test.text, test.rest, test.ok, rest, ok)
+		}
+	}
+}
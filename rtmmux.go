@@ -0,0 +1,164 @@
+package slackgw
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+)
+
+// SlackRTMHandlerFunc allows ordinary functions to be used as
+// SlackRTMHandler
+type SlackRTMHandlerFunc func(*RTMCtx) error
+
+func (f SlackRTMHandlerFunc) Handle(ctx *RTMCtx) error {
+	return f(ctx)
+}
+
+// RTMMux is a SlackRTMHandler that passes each event to all of its
+// handlers, in the order they were added. An error from one handler does
+// not keep the event from the others, nor does it stop the RTM loop:
+// it is passed to ErrorHandler instead.
+//
+// Handlers are called one after the other, on the goroutine that reads
+// from the RTM connection, so a slow handler delays the others and the
+// events after it. Handlers that may take a while should hand the work
+// off to their own goroutine or queue, like CommandRouter and
+// WebhookForwarder do
+type RTMMux struct {
+	ErrorHandler func(SlackRTMHandler, *RTMCtx, error) // if non nil, called for each error returned by a handler
+
+	mutex    sync.RWMutex
+	handlers []SlackRTMHandler
+}
+
+// NewRTMMux creates a new RTMMux that passes events to handlers
+func NewRTMMux(handlers ...SlackRTMHandler) *RTMMux {
+	return &RTMMux{handlers: handlers}
+}
+
+// Add adds h to the handlers. It is safe to call while events are being
+// handled
+func (m *RTMMux) Add(h SlackRTMHandler) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.handlers = append(m.handlers, h)
+}
+
+func (m *RTMMux) Handle(ctx *RTMCtx) error {
+	m.mutex.RLock()
+	handlers := m.handlers
+	m.mutex.RUnlock()
+
+	for _, h := range handlers {
+		if err := h.Handle(ctx); err != nil {
+			if pdebug.Enabled {
+				pdebug.Printf("RTMMux: %T: %s", h, err)
+			}
+			if m.ErrorHandler != nil {
+				m.ErrorHandler(h, ctx, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the handlers that implement io.Closer, such as
+// WebhookForwarder. It returns the first error
+func (m *RTMMux) Close() error {
+	m.mutex.RLock()
+	handlers := m.handlers
+	m.mutex.RUnlock()
+
+	var err error
+	for _, h := range handlers {
+		c, ok := h.(io.Closer)
+		if !ok {
+			continue
+		}
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// RTMMiddleware wraps a SlackRTMHandler to add behavior to it
+type RTMMiddleware func(SlackRTMHandler) SlackRTMHandler
+
+// ChainRTM wraps h with middlewares. The first middleware is the
+// outermost one, i.e. it sees the event first. If h implements
+// io.Closer, so does the result
+func ChainRTM(h SlackRTMHandler, middlewares ...RTMMiddleware) SlackRTMHandler {
+	chained := h
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		chained = middlewares[i](chained)
+	}
+	if c, ok := h.(io.Closer); ok {
+		return &closingRTMHandler{SlackRTMHandler: chained, closer: c}
+	}
+	return chained
+}
+
+// closingRTMHandler lets a chain be closed like the handler it wraps
+type closingRTMHandler struct {
+	SlackRTMHandler
+	closer io.Closer
+}
+
+func (h *closingRTMHandler) Close() error {
+	return h.closer.Close()
+}
+
+// FilterRTM only passes the events for which f returns true
+func FilterRTM(f func(*RTMCtx) bool) RTMMiddleware {
+	return func(h SlackRTMHandler) SlackRTMHandler {
+		return SlackRTMHandlerFunc(func(ctx *RTMCtx) error {
+			if !f(ctx) {
+				return nil
+			}
+			return h.Handle(ctx)
+		})
+	}
+}
+
+// FilterRTMEvents only passes the specified events (e.g. MessageEvent)
+func FilterRTMEvents(events ...int64) RTMMiddleware {
+	var mask int64
+	for _, ev := range events {
+		mask |= ev
+	}
+	return FilterRTM(func(ctx *RTMCtx) bool {
+		return mask&MaskOf(ctx.Event) != 0
+	})
+}
+
+// RecoverRTM turns panics in the handler into errors, so that a buggy
+// handler does not take down the whole process
+func RecoverRTM() RTMMiddleware {
+	return func(h SlackRTMHandler) SlackRTMHandler {
+		return SlackRTMHandlerFunc(func(ctx *RTMCtx) (err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					err = errors.Errorf("panic while handling %s event: %v", ctx.Event.Type, v)
+				}
+			}()
+			return h.Handle(ctx)
+		})
+	}
+}
+
+// TimeRTM calls f with the time it took the handler to handle each
+// event, along with the error it returned
+func TimeRTM(f func(ctx *RTMCtx, elapsed time.Duration, err error)) RTMMiddleware {
+	return func(h SlackRTMHandler) SlackRTMHandler {
+		return SlackRTMHandlerFunc(func(ctx *RTMCtx) error {
+			start := time.Now()
+			err := h.Handle(ctx)
+			f(ctx, time.Since(start), err)
+			return err
+		})
+	}
+}
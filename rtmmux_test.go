@@ -0,0 +1,86 @@
+package slackgw
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestRTMMux(t *testing.T) {
+	var got []string
+	record := func(name string) SlackRTMHandler {
+		return SlackRTMHandlerFunc(func(ctx *RTMCtx) error {
+			got = append(got, name+":"+ctx.Event.Type)
+			return nil
+		})
+	}
+
+	var errs []error
+	var timed int
+	m := NewRTMMux(
+		ChainRTM(SlackRTMHandlerFunc(func(*RTMCtx) error { panic("oops") }), RecoverRTM()),
+		SlackRTMHandlerFunc(func(*RTMCtx) error { return errors.New("failed") }),
+		ChainRTM(record("messages"),
+			TimeRTM(func(_ *RTMCtx, elapsed time.Duration, _ error) {
+				if elapsed < 0 {
+					t.Errorf("negative elapsed time %s", elapsed)
+				}
+				timed++
+			}),
+			FilterRTMEvents(MessageEvent),
+		),
+	)
+	m.ErrorHandler = func(_ SlackRTMHandler, _ *RTMCtx, err error) { errs = append(errs, err) }
+	m.Add(record("all"))
+
+	for _, ev := range []slack.RTMEvent{
+		{Type: "hello", Data: &slack.HelloEvent{}},
+		newTestMessageEvent("C1", "hello"),
+	} {
+		if err := m.Handle(&RTMCtx{Event: ev}); err != nil {
+			t.Errorf("expected no error from mux, got %s", err)
+		}
+	}
+
+	expected := []string{"all:hello", "messages:message", "all:message"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, got)
+			break
+		}
+	}
+
+	if len(errs) != 4 {
+		t.Errorf("expected 4 errors, got %v", errs)
+	}
+	if timed != 2 {
+		t.Errorf("expected timing for 2 events, got %d", timed)
+	}
+}
+
+type closingTestHandler struct {
+	closed int
+}
+
+func (h *closingTestHandler) Handle(*RTMCtx) error { return nil }
+
+func (h *closingTestHandler) Close() error {
+	h.closed++
+	return nil
+}
+
+func TestRTMMuxClose(t *testing.T) {
+	h := &closingTestHandler{}
+	m := NewRTMMux(ChainRTM(h, RecoverRTM()), SlackRTMHandlerFunc(func(*RTMCtx) error { return nil }))
+	if err := m.Close(); err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+	if h.closed != 1 {
+		t.Errorf("expected the chained handler to be closed once, got %d", h.closed)
+	}
+}
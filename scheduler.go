@@ -0,0 +1,277 @@
+package slackgw
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+)
+
+// Duration is a time.Duration that is represented in JSON as a string
+// such as "90s" or "5m", or as a number of seconds
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(buf []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		x, err := parseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(x)
+	default:
+		return errors.Errorf("invalid duration: %s", buf)
+	}
+	return nil
+}
+
+// parseDuration parses either a duration such as "90s", or a number of
+// seconds. Form values and JSON accept the same formats
+func parseDuration(v string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	return time.ParseDuration(v)
+}
+
+// parseTime parses either an RFC3339 timestamp, or UNIX epoch seconds
+func parseTime(v string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// UnmarshalJSON accepts post_at as UNIX epoch seconds as well as RFC3339,
+// like form values do
+func (msg *Message) UnmarshalJSON(buf []byte) error {
+	type message Message // does not have the UnmarshalJSON method
+	v := struct {
+		*message
+		PostAt interface{} `json:"post_at"`
+	}{message: (*message)(msg)}
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return err
+	}
+
+	switch t := v.PostAt.(type) {
+	case nil:
+	case float64:
+		msg.PostAt = time.Unix(int64(t), 0)
+	case string:
+		x, err := parseTime(t)
+		if err != nil {
+			return errors.Wrap(err, "invalid value for post_at")
+		}
+		msg.PostAt = x
+	default:
+		return errors.Errorf("invalid value for post_at: %v", t)
+	}
+	return nil
+}
+
+// ScheduledMessage describes a message waiting to be sent
+type ScheduledMessage struct {
+	ID      string    `json:"id"`
+	Channel string    `json:"channel"`
+	Message string    `json:"message"`
+	PostAt  time.Time `json:"post_at"`
+	owner   string
+}
+
+type scheduledEntry struct {
+	info  ScheduledMessage
+	msg   *Message
+	timer *time.Timer
+}
+
+// scheduler holds messages until they are due, and then hands them to
+// the sender. Each message gets its own timer
+type scheduler struct {
+	mutex   sync.Mutex
+	entries map[string]*scheduledEntry
+}
+
+// isScheduled returns true if msg should not be sent right away. Relative
+// delays are converted to an absolute time first
+func (msg *Message) isScheduled(now time.Time) bool {
+	if msg.Delay > 0 {
+		msg.PostAt = now.Add(time.Duration(msg.Delay))
+		msg.Delay = 0
+	}
+	return msg.PostAt.After(now)
+}
+
+// scheduleMessage holds on to msg until msg.PostAt, and then sends it.
+// msg is released once it has been sent (or cancelled)
+func (s *Server) scheduleMessage(msg *Message) (*ScheduledMessage, error) {
+	if s.done == nil {
+		return nil, errors.New("server is not connected or is shutting down")
+	}
+
+	// Write the message to the outbox now, so that it survives a restart.
+	// It's rescheduled when it gets replayed
+	if s.Outbox != nil && msg.outboxID == 0 {
+		if err := s.Outbox.Append(msg); err != nil {
+			return nil, errors.Wrap(err, "failed to write message to outbox")
+		}
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	e := &scheduledEntry{
+		info: ScheduledMessage{
+			ID:      id,
+			Channel: msg.Channel,
+			Message: msg.Message,
+			PostAt:  msg.PostAt,
+			owner:   msg.owner,
+		},
+		msg: msg,
+	}
+
+	sc := &s.scheduler
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	if sc.entries == nil {
+		sc.entries = make(map[string]*scheduledEntry)
+	}
+	sc.entries[id] = e
+	e.timer = time.AfterFunc(msg.PostAt.Sub(time.Now()), func() { s.releaseScheduled(id) })
+
+	if pdebug.Enabled {
+		pdebug.Printf("scheduled message %s for %s", id, msg.PostAt)
+	}
+
+	info := e.info
+	return &info, nil
+}
+
+// releaseScheduled sends the scheduled message with the given ID
+func (s *Server) releaseScheduled(id string) {
+	sc := &s.scheduler
+	sc.mutex.Lock()
+	e, ok := sc.entries[id]
+	delete(sc.entries, id)
+	sc.mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	if pdebug.Enabled {
+		pdebug.Printf("releasing scheduled message %s", id)
+	}
+
+	defer releaseMessage(e.msg)
+	e.msg.background = true // keep transient failures in the outbox
+	if err := s.postMessage(e.msg); err != nil && pdebug.Enabled {
+		pdebug.Printf("failed to send scheduled message %s: %s", id, err)
+	}
+}
+
+// cancelScheduled removes a scheduled message before it is sent.
+// Returns false if there is no such message for owner
+func (s *Server) cancelScheduled(id, owner string) bool {
+	sc := &s.scheduler
+	sc.mutex.Lock()
+	e, ok := sc.entries[id]
+	if !ok || (owner != "" && e.info.owner != owner) || !e.timer.Stop() {
+		sc.mutex.Unlock()
+		return false
+	}
+	delete(sc.entries, id)
+	sc.mutex.Unlock()
+
+	if s.Outbox != nil && e.msg.outboxID != 0 {
+		if err := s.Outbox.Ack(e.msg); err != nil && pdebug.Enabled {
+			pdebug.Printf("failed to acknowledge cancelled message in outbox: %s", err)
+		}
+	}
+	releaseMessage(e.msg)
+	return true
+}
+
+// listScheduled returns the messages scheduled by owner, or all
+// messages if owner is empty
+func (s *Server) listScheduled(owner string) []ScheduledMessage {
+	sc := &s.scheduler
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	list := []ScheduledMessage{}
+	for _, e := range sc.entries {
+		if owner == "" || e.info.owner == owner {
+			list = append(list, e.info)
+		}
+	}
+	return list
+}
+
+// stopScheduler stops all timers. Messages that are in the outbox will
+// be scheduled again on the next start
+func (s *Server) stopScheduler() {
+	sc := &s.scheduler
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	for id, e := range sc.entries {
+		e.timer.Stop()
+		delete(sc.entries, id)
+	}
+}
+
+func (s *Server) httpScheduled(w http.ResponseWriter, r *http.Request) {
+	cred, err := s.authenticate(r)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to authenticate: %s", err)
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	var owner string
+	if cred != nil {
+		owner = cred.Name
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/scheduled"), "/")
+	switch strings.ToLower(r.Method) {
+	case "get":
+		if id != "" {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(s.listScheduled(owner))
+	case "delete":
+		if id == "" || !s.cancelScheduled(id, owner) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Cancelled"))
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,122 @@
+package slackgw
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestScheduledMessages(t *testing.T) {
+	s0 := New()
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	sent := make(chan string, 1)
+	go func() {
+		for msg := range s0.bus {
+			sent <- msg.Message
+			msg.dst <- nil
+		}
+	}()
+
+	schedule := func(v url.Values) ScheduledMessage {
+		res, err := http.PostForm(s.URL+"/post", v)
+		if err != nil {
+			t.Fatalf("failed to post: %s", err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d", res.StatusCode)
+		}
+		var info ScheduledMessage
+		if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+			t.Fatalf("failed to decode: %s", err)
+		}
+		return info
+	}
+
+	later := schedule(url.Values{"channel": {"#test"}, "message": {"later"}, "post_at": {time.Now().Add(time.Hour).Format(time.RFC3339)}})
+	soon := schedule(url.Values{"channel": {"#test"}, "message": {"soon"}, "delay": {"50ms"}})
+
+	res, err := http.Get(s.URL + "/scheduled")
+	if err != nil {
+		t.Fatalf("failed to list: %s", err)
+	}
+	var list []ScheduledMessage
+	json.NewDecoder(res.Body).Decode(&list)
+	res.Body.Close()
+	if len(list) != 2 {
+		t.Errorf("expected 2 scheduled messages, got %d", len(list))
+	}
+
+	req, _ := http.NewRequest("DELETE", s.URL+"/scheduled/"+later.ID, nil)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to cancel: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", res.StatusCode)
+	}
+
+	select {
+	case text := <-sent:
+		if text != "soon" {
+			t.Errorf("expected 'soon' to be sent, got '%s'", text)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for scheduled message %s", soon.ID)
+	}
+
+	if list := s0.listScheduled(""); len(list) != 0 {
+		t.Errorf("expected no scheduled messages, got %d", len(list))
+	}
+}
+
+func TestScheduleFormats(t *testing.T) {
+	postAt := time.Unix(1461816000, 0)
+	for _, text := range []string{
+		`{"post_at":1461816000}`,
+		`{"post_at":"1461816000"}`,
+		`{"post_at":"2016-04-28T13:00:00+09:00"}`,
+	} {
+		var msg Message
+		if err := json.Unmarshal([]byte(text), &msg); err != nil {
+			t.Errorf("failed to decode %s: %s", text, err)
+			continue
+		}
+		if !msg.PostAt.Equal(postAt) {
+			t.Errorf("%s: expected %s, got %s", text, postAt, msg.PostAt)
+		}
+	}
+
+	for _, text := range []string{`{"delay":90}`, `{"delay":"90"}`, `{"delay":"1m30s"}`} {
+		var msg Message
+		if err := json.Unmarshal([]byte(text), &msg); err != nil {
+			t.Errorf("failed to decode %s: %s", text, err)
+			continue
+		}
+		if time.Duration(msg.Delay) != 90*time.Second {
+			t.Errorf("%s: expected 90s, got %s", text, time.Duration(msg.Delay))
+		}
+	}
+
+	var msg Message
+	if err := json.Unmarshal([]byte(`{"channel":"#test","post_at":"tomorrow"}`), &msg); err == nil {
+		t.Errorf("expected invalid post_at to be rejected")
+	}
+	if err := json.Unmarshal([]byte(`{"channel":"#test","message":"hi"}`), &msg); err != nil || msg.Channel != "#test" || !msg.PostAt.IsZero() {
+		t.Errorf("expected plain message to be decoded, got %#v (%v)", msg, err)
+	}
+
+	// Form values take the same formats
+	for _, v := range []string{"90", "1m30s"} {
+		if d, err := parseDuration(v); err != nil || d != 90*time.Second {
+			t.Errorf("expected 90s for '%s', got %s (%v)", v, d, err)
+		}
+	}
+}
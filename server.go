@@ -1,14 +1,21 @@
 package slackgw
 
 import (
+	"bytes"
 	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/lestrrat/go-pdebug"
 	"github.com/nlopes/slack"
@@ -20,8 +27,26 @@ func New() *Server {
 	s := &Server{ServeMux: mux}
 	mux.HandleFunc("/", s.httpWelcome)
 	mux.HandleFunc("/post", s.httpPostMessage)
+	// takosan compatible endpoints
+	mux.HandleFunc("/notice", s.httpPostMessage)
+	mux.HandleFunc("/privmsg", s.httpPostMessage)
+	mux.HandleFunc("/update", s.httpUpdateMessage)
+	mux.HandleFunc("/delete", s.httpDeleteMessage)
+	mux.HandleFunc("/jobs/", s.httpJobStatus)
+	mux.HandleFunc("/scheduled", s.httpScheduled)
+	mux.HandleFunc("/scheduled/", s.httpScheduled)
+	mux.HandleFunc("/services/", s.httpWebhook)
+	mux.HandleFunc("/alertmanager", s.httpAlertmanager)
+	mux.HandleFunc("/alertmanager/", s.httpAlertmanager)
+	mux.HandleFunc("/github", s.httpGitHub)
+	mux.HandleFunc("/gitlab", s.httpGitLab)
+	mux.HandleFunc("/hook/", s.httpHook)
+	mux.HandleFunc(EventStreamEndpoint, s.httpEventStream)
 	s.done = make(chan struct{})
 	s.bus = make(chan *Message, 255)
+	s.MessageKeys = NewMemoryMessageKeyStore()
+	s.Retry = DefaultRetryPolicy
+	s.ChannelInterval = DefaultChannelInterval
 	return s
 }
 
@@ -41,6 +66,23 @@ func (s *Server) Close() error {
 		}
 		s.rtm.Disconnect()
 	}
+
+	// Handlers such as WebhookForwarder hold events that must be flushed
+	if c, ok := s.rtmhandler.(io.Closer); ok {
+		if pdebug.Enabled {
+			pdebug.Printf("Closing RTM handler...")
+		}
+		c.Close()
+	}
+
+	s.stopScheduler()
+
+	if s.Outbox != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("Closing outbox...")
+		}
+		s.Outbox.Close()
+	}
 	return nil
 }
 
@@ -69,17 +111,29 @@ func (s *Server) StartSlack(token string) error {
 		return errors.Wrap(err, "Slack auth test failed")
 	}
 	s.slackuser = auth.UserID // so we know what to respond to
+	s.teamURL = auth.URL      // so we can construct permalinks
 
 	// Start waiting for outgoing messages
 	go s.watchOutgoingMessages()
+
+	// Resend whatever we did not get to send last time
+	if s.Outbox != nil {
+		go s.replayOutbox()
+	}
 	return nil
 }
 
+// StartRTM connects to slack's RTM API, and passes incoming events to h.
+// h may be nil, if the events are only consumed by streaming clients
 func (s *Server) StartRTM(h SlackRTMHandler) error {
 	if pdebug.Enabled {
 		pdebug.Printf("Starting RTM client...")
 	}
 
+	if s.slack == nil {
+		return errors.New("slack client has not been started")
+	}
+
 	rtm := s.slack.NewRTM()
 	s.rtm = rtm
 	s.rtmhandler = h
@@ -94,7 +148,7 @@ func (s *Server) StartRTM(h SlackRTMHandler) error {
 func (s *Server) Run() error {
 	// Setup a signal handler so we know when to properly disconnect
 	sigch := make(chan os.Signal, 255)
-	signal.Notify(sigch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	signal.Notify(sigch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP)
 
 	// Wait for close requests
 	if pdebug.Enabled {
@@ -107,9 +161,13 @@ func (s *Server) Run() error {
 				pdebug.Printf("Deteced 'done' state...")
 			}
 			loop = false
-		case <-sigch:
+		case sig := <-sigch:
 			if pdebug.Enabled {
-				pdebug.Printf("Received signal...")
+				pdebug.Printf("Received signal %s...", sig)
+			}
+			if sig == syscall.SIGHUP {
+				s.reload()
+				continue
 			}
 			s.Close()
 			loop = false
@@ -121,6 +179,28 @@ func (s *Server) Run() error {
 	return nil
 }
 
+func (s *Server) reload() {
+	if s.Reload == nil {
+		return
+	}
+	if err := s.Reload(); err != nil && pdebug.Enabled {
+		pdebug.Printf("failed to reload: %s", err)
+	}
+}
+
+// Reconfigure calls f with the configuration locked, so that f may change
+// WebhookChannel, AlertTemplate, AlertRoutes, AlertChannel, Hooks,
+// RepoChannels and SMTPChannels while the server is running. Other fields
+// must not be changed once the server has been started
+func (s *Server) Reconfigure(f func(*Server)) {
+	s.config.Lock()
+	defer s.config.Unlock()
+	f(s)
+}
+
+// how long a channel worker waits for new messages before going away
+const channelWorkerIdleTimeout = time.Minute
+
 func (s *Server) watchOutgoingMessages() {
 	if pdebug.Enabled {
 		pdebug.Printf("starting watchOutgoingMessages...")
@@ -134,43 +214,193 @@ func (s *Server) watchOutgoingMessages() {
 	bus := s.bus
 	client := s.slack
 
+	// Each channel gets its own worker, so that pacing or retrying
+	// messages to one channel does not hold up the others. Workers are
+	// keyed by channel ID where it is known, so that messages addressed
+	// to #general and to its ID share the same pacing
+	workers := make(map[string]*channelQueue)
+	idle := make(chan string)
 	for {
 		select {
 		case <-done:
 			return
+		case key := <-idle:
+			// Only retire the worker if nothing has been queued in the meantime
+			if q, ok := workers[key]; ok && q.empty() {
+				close(q.ready)
+				delete(workers, key)
+			}
 		case wrapped := <-bus:
 			if pdebug.Enabled {
 				pdebug.Printf("New outgoing message, sending to '%s'", wrapped.Channel)
 			}
-			_, _, err := client.PostMessage(wrapped.Channel, wrapped.Message, wrapped.Params)
+			key := s.channelIDs.lookup(wrapped.Channel)
+			q, ok := workers[key]
+			if !ok {
+				// The worker may have been started before the ID of the
+				// channel was known, in which case it goes by the name
+				if name, found := s.channelIDs.name(key); found {
+					key = name
+					q, ok = workers[key]
+				}
+			}
+			if !ok {
+				q = newChannelQueue()
+				workers[key] = q
+				go s.channelWorker(client, key, q, idle, done)
+			}
+			q.push(wrapped)
+		}
+	}
+}
+
+// channelWorker sends the messages queued for a single channel, at
+// most one per Server.ChannelInterval
+func (s *Server) channelWorker(client SlackClient, key string, q *channelQueue, idle chan<- string, done <-chan struct{}) {
+	var last time.Time
+	for {
+		wrapped, ok := q.pop()
+		if !ok {
+			select {
+			case <-done:
+				return
+			case _, ok := <-q.ready:
+				if !ok {
+					// Retired. Nothing is queued anymore, since only the
+					// dispatcher queues messages
+					return
+				}
+			case <-time.After(channelWorkerIdleTimeout):
+				select {
+				case <-done:
+					return
+				case idle <- key:
+				}
+			}
+			continue
+		}
+
+		if wait := s.ChannelInterval - time.Since(last); wait > 0 {
+			select {
+			case <-done:
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		err := s.sendMessage(client, wrapped, done)
+		last = time.Now()
+
+		// Messages replayed from the outbox have nobody waiting for them
+		if wrapped.dst != nil {
 			wrapped.dst <- err
+		} else {
+			if err != nil && pdebug.Enabled {
+				pdebug.Printf("failed to send replayed message: %s", err)
+			}
+			s.resolveReplayed(wrapped, err)
 		}
 	}
 }
 
+// sendMessage delivers msg to slack, and records the outcome
+func (s *Server) sendMessage(client SlackClient, msg *Message, done <-chan struct{}) error {
+	channel, ts, err := s.deliverWithRetry(client, msg, done)
+	msg.postedChannel = channel
+	msg.postedTS = ts
+	if err == nil && msg.op == opPost {
+		s.channelIDs.set(msg.Channel, channel)
+	}
+
+	// When the error goes back to the caller, it is up to them to try
+	// again, so the message must not be replayed as well. Otherwise,
+	// messages that failed transiently, or whose delivery was cut short
+	// by a shutdown, are left in the outbox, so that they are sent again
+	// on the next start
+	ack := true
+	if err != nil && (msg.dst == nil || msg.background) {
+		select {
+		case <-done:
+			ack = false
+		default:
+			_, transient := retryAfter(err)
+			ack = !transient
+		}
+	}
+
+	if err == nil && msg.Key != "" {
+		err = s.recordMessageKey(msg)
+	}
+
+	if ack && s.Outbox != nil && msg.outboxID != 0 {
+		if aerr := s.Outbox.Ack(msg); aerr != nil && pdebug.Enabled {
+			pdebug.Printf("failed to acknowledge message in outbox: %s", aerr)
+		}
+	}
+	return err
+}
+
+// deliver sends msg to slack, according to the operation requested
+func deliver(client SlackClient, msg *Message) (string, string, error) {
+	switch msg.op {
+	case opUpdate:
+		// Pass the same parameters as posts, so that updates (including
+		// upserts by key) keep their attachments and such
+		params := msg.postParams()
+		channel, ts, _, err := client.SendMessage(msg.Channel,
+			slack.MsgOptionUpdate(msg.Timestamp),
+			slack.MsgOptionText(msg.Message, false),
+			slack.MsgOptionPostMessageParameters(params),
+			slack.MsgOptionAttachments(params.Attachments...),
+		)
+		return channel, ts, err
+	case opDelete:
+		return client.DeleteMessage(msg.Channel, msg.Timestamp)
+	case opUpload:
+		if msg.file == nil {
+			return "", "", errors.New("no file to upload")
+		}
+		// Content is sent as a text snippet, which would mangle binaries.
+		// A new Reader is created for each attempt, so retries work
+		_, err := client.UploadFile(slack.FileUploadParameters{
+			Reader:          bytes.NewReader(msg.file.Content),
+			Filename:        msg.file.Filename,
+			Filetype:        msg.file.Filetype,
+			Title:           msg.file.Title,
+			InitialComment:  msg.Message,
+			Channels:        []string{msg.Channel},
+			ThreadTimestamp: msg.ThreadTimestamp,
+		})
+		return msg.Channel, "", err
+	default:
+		return client.PostMessage(msg.Channel, msg.Message, msg.postParams())
+	}
+}
+
 func (s *Server) httpWelcome(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Welcome"))
 }
 
 func (s *Server) Authorized(token string) bool {
-	// XXX For now we're just going to check for a single value, but
-	// we probably should think of a better auth/authz backend
+	if s.Credentials != nil {
+		c, err := s.Credentials.Lookup(token)
+		return err == nil && c.Enabled && !c.RequireSignature
+	}
 	return s.AuthToken == token
 }
 
-func (s *Server) httpPostMessage(w http.ResponseWriter, r *http.Request) {
-	if pdebug.Enabled {
-		pdebug.Printf("http: posting new message...")
-		defer pdebug.Printf("done posting new message")
-	}
-
+// readMessage authenticates the request, and extracts the message out of
+// it. If anything goes wrong, an error response is sent and nil is returned
+func (s *Server) readMessage(w http.ResponseWriter, r *http.Request) *Message {
 	// Check for authentication
-	if hdrname := s.AuthHeader; hdrname != "" {
-		if !s.Authorized(r.Header.Get(hdrname)) {
-			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
-			return
+	cred, err := s.authenticate(r)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to authenticate: %s", err)
 		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil
 	}
 
 	msg, err := s.extractMessage(r)
@@ -179,44 +409,415 @@ func (s *Server) httpPostMessage(w http.ResponseWriter, r *http.Request) {
 			pdebug.Printf("failed to extract message: %s", err)
 		}
 		http.Error(w, "Failed to parse request: "+err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+
+	if cred != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("request from client '%s'", cred.Name)
+		}
+		if !s.allowsChannel(cred, msg.Channel) {
+			releaseMessage(msg)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return nil
+		}
+		msg.owner = cred.Name
+	}
+	return msg
+}
+
+func (s *Server) httpPostMessage(w http.ResponseWriter, r *http.Request) {
+	if pdebug.Enabled {
+		pdebug.Printf("http: posting new message...")
+		defer pdebug.Printf("done posting new message")
+	}
+
+	msg := s.readMessage(w, r)
+	if msg == nil {
+		return
+	}
+
+	if msg.op == opUpload && msg.Key != "" {
+		releaseMessage(msg)
+		http.Error(w, errUploadWithKey.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.verifyThread(msg); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to verify thread: %s", err)
+		}
+		releaseMessage(msg)
+		http.Error(w, "Invalid thread: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.dispatch(w, r, msg)
+}
+
+func (s *Server) httpUpdateMessage(w http.ResponseWriter, r *http.Request) {
+	if pdebug.Enabled {
+		pdebug.Printf("http: updating message...")
+		defer pdebug.Printf("done updating message")
+	}
+
+	msg := s.readMessage(w, r)
+	if msg == nil {
+		return
+	}
+	msg.op = opUpdate
+
+	if msg.Timestamp == "" && msg.Key == "" {
+		releaseMessage(msg)
+		http.Error(w, "Either ts or key must be specified", http.StatusBadRequest)
+		return
+	}
+
+	s.dispatch(w, r, msg)
+}
+
+func (s *Server) httpDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	if pdebug.Enabled {
+		pdebug.Printf("http: deleting message...")
+		defer pdebug.Printf("done deleting message")
+	}
+
+	msg := s.readMessage(w, r)
+	if msg == nil {
+		return
+	}
+	msg.op = opDelete
+
+	if msg.Timestamp == "" && msg.Key == "" {
+		releaseMessage(msg)
+		http.Error(w, "Either ts or key must be specified", http.StatusBadRequest)
+		return
+	}
+
+	s.dispatch(w, r, msg)
+}
+
+// dispatch sends msg, honoring the idempotency key if the client sent one
+func (s *Server) dispatch(w http.ResponseWriter, r *http.Request, msg *Message) {
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		s.dispatchIdempotent(w, r, msg, key)
 		return
 	}
-	defer msgPool.Put(msg)
+	s.dispatchMessage(w, r, msg)
+}
 
+// dispatchMessage hands msg over to the sender, and writes the response.
+// msg is released once it has been sent
+func (s *Server) dispatchMessage(w http.ResponseWriter, r *http.Request, msg *Message) {
 	if pdebug.Enabled {
 		pdebug.Printf("message to send: %#v", msg)
 	}
 
+	if msg.isScheduled(time.Now()) {
+		// The scheduler owns msg from here on, and releases it when done
+		info, err := s.scheduleMessage(msg)
+		if err != nil {
+			releaseMessage(msg)
+			http.Error(w, "Failed to schedule message: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(info)
+		return
+	}
+
+	if isAsync(r) {
+		// The job owns msg from here on, and releases it when done
+		j, err := s.postMessageAsync(msg)
+		if err != nil {
+			releaseMessage(msg)
+			http.Error(w, "Failed to queue message: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/jobs/"+j.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(j)
+		return
+	}
+	defer releaseMessage(msg)
+
 	// Note: this function WILL block until we get a response from the
-	// slack server, because HTTP is... blocky.
-	if err := s.postMessage(msg); err != nil {
+	// slack server, because HTTP is... blocky. The exception is when the
+	// message is safe in the outbox but the sender is busy: there is no
+	// point in keeping the client waiting then
+	err := s.postMessageOrQueue(msg)
+	if err == errMessageQueued {
+		if acceptsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(map[string]string{"status": JobQueued})
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("Queued"))
+		return
+	}
+	if err != nil {
 		if pdebug.Enabled {
 			pdebug.Printf("failed to post message: %s", err)
 		}
-		http.Error(w, "Failed to post message: "+err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if errors.Cause(err) == errMessageKeyNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(w, "Failed to post message: "+err.Error(), status)
+		return
+	}
+
+	if acceptsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&PostResult{
+			Channel:   msg.postedChannel,
+			Timestamp: msg.postedTS,
+			Permalink: s.permalink(msg),
+		})
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Sent"))
+	switch msg.op {
+	case opUpdate:
+		w.Write([]byte("Updated"))
+	case opDelete:
+		w.Write([]byte("Deleted"))
+	default:
+		w.Write([]byte("Sent"))
+	}
+}
+
+// PostResult is returned from /post when the client accepts JSON
+type PostResult struct {
+	Channel   string `json:"channel"`
+	Timestamp string `json:"ts"`
+	Permalink string `json:"permalink,omitempty"`
 }
 
+func acceptsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// permalink constructs the URL to msg once it has been posted, from the
+// team URL reported by slack, e.g.
+// https://team.slack.com/archives/C024BE91L/p1461720000000002. Replies
+// link to their thread, like the permalinks slack hands out
+func (s *Server) permalink(msg *Message) string {
+	channel, ts := msg.postedChannel, msg.postedTS
+	if s.teamURL == "" || channel == "" || ts == "" {
+		return ""
+	}
+	link := strings.TrimSuffix(s.teamURL, "/") + "/archives/" + channel + "/p" + strings.Replace(ts, ".", "", 1)
+	if thread := msg.postParams().ThreadTimestamp; thread != "" && thread != ts {
+		link += "?" + url.Values{"thread_ts": {thread}, "cid": {channel}}.Encode()
+	}
+	return link
+}
+
+// isChannelID returns true if ch looks like a channel, group or IM ID
+// (e.g. C024BE91L) as opposed to a name (e.g. #general)
+func isChannelID(ch string) bool {
+	if len(ch) < 2 {
+		return false
+	}
+	switch ch[0] {
+	case 'C', 'G', 'D':
+	default:
+		return false
+	}
+	for _, c := range ch[1:] {
+		if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyThread makes sure that the parent of a threaded reply exists.
+// Slack only allows us to look up messages by channel ID, so replies
+// addressed to a channel name (e.g. #general) are sent without
+// verification, and fail when Slack cannot find the parent
+func (s *Server) verifyThread(msg *Message) error {
+	parent := msg.ThreadTimestamp
+	if parent == "" {
+		parent = msg.Params.ThreadTimestamp
+	}
+	if parent == "" {
+		if msg.ReplyBroadcast || msg.Params.ReplyBroadcast {
+			return errors.New("reply_broadcast requires thread_ts")
+		}
+		return nil
+	}
+
+	if !isChannelID(msg.Channel) {
+		return nil
+	}
+
+	if s.slack == nil {
+		return errors.New("server is not connected")
+	}
+
+	// conversations.replies works for public and private channels as
+	// well as DMs, unlike channels.replies. The parent is the first
+	// message of the thread
+	replies, _, _, err := s.slack.GetConversationReplies(&slack.GetConversationRepliesParameters{
+		ChannelID: msg.Channel,
+		Timestamp: parent,
+		Limit:     1,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch thread")
+	}
+	for _, reply := range replies {
+		if reply.Timestamp == parent {
+			return nil
+		}
+	}
+	return errors.Errorf("parent message %s not found in %s", parent, msg.Channel)
+}
+
+// errMessageQueued is returned by postMessageOrQueue when the message has
+// been written to the outbox, and will be sent in the background
+var errMessageQueued = errors.New("message queued")
+
+// postMessage sends msg, and waits for slack to respond
 func (s *Server) postMessage(msg *Message) error {
-	if s.done == nil {
+	return s.submitMessage(msg, false)
+}
+
+// postMessageOrQueue is like postMessage, except that when the message is
+// safe in the outbox and the sender is busy, it does not wait for its turn.
+// errMessageQueued is returned instead, and the message is sent in the
+// background. msg may be released by the caller either way
+func (s *Server) postMessageOrQueue(msg *Message) error {
+	return s.submitMessage(msg, true)
+}
+
+func (s *Server) submitMessage(msg *Message, mayQueue bool) error {
+	done := s.done
+	if done == nil {
 		return errors.New("server is not connected or is shutting down")
 	}
 
-	dst := make(chan error)
+	unlock := func() {}
+	if msg.Key != "" {
+		// Hold the key until the message has been sent and recorded,
+		// otherwise concurrent upserts would all post a new message
+		unlock = s.msgkeyLocks.lock(messageKey(msg))
+		if err := s.resolveMessageKey(msg); err != nil {
+			unlock()
+			return err
+		}
+	}
+
+	if s.Outbox != nil && msg.outboxID == 0 {
+		if err := s.Outbox.Append(msg); err != nil {
+			unlock()
+			return errors.Wrap(err, "failed to write message to outbox")
+		}
+	}
+
+	dst := make(chan error, 1)
 	msg.dst = dst
-	s.bus <- msg
-	return <-dst
+	if !mayQueue || s.Outbox == nil {
+		defer unlock()
+		s.bus <- msg
+		return <-dst
+	}
+
+	select {
+	case s.bus <- msg:
+		defer unlock()
+		return <-dst
+	default:
+	}
+
+	// The caller may release msg as soon as we return, so queue a copy
+	queued := *msg
+	queued.dst = make(chan error, 1)
+	queued.idempotencyKey = "" // resolved by the caller with the "queued" response
+	queued.background = true
+	go s.sendQueued(&queued, unlock, done)
+	return errMessageQueued
+}
+
+// sendQueued hands a message that is already in the outbox to the sender,
+// once there is room on the bus. If the server shuts down first, the
+// message stays in the outbox, and is sent on the next start
+func (s *Server) sendQueued(msg *Message, unlock func(), done <-chan struct{}) {
+	defer unlock()
+
+	select {
+	case <-done:
+		return
+	case s.bus <- msg:
+	}
+
+	select {
+	case <-done:
+	case err := <-msg.dst:
+		if err != nil && pdebug.Enabled {
+			pdebug.Printf("failed to send queued message: %s", err)
+		}
+	}
+}
+
+// Operations that can be performed with a Message
+const (
+	opPost = iota
+	opUpdate
+	opDelete
+	opUpload
+)
+
+// messageFile is the file uploaded by opUpload messages
+type messageFile struct {
+	Filename string `json:"filename"`
+	Filetype string `json:"filetype"`
+	Title    string `json:"title"`
+	Content  []byte `json:"content"` // may be binary, so it's base64 encoded in JSON
 }
 
 type Message struct {
-	Channel string                      `json:"channel"`
-	Message string                      `json:"message"`
-	Params  slack.PostMessageParameters `json:"params"`
-	dst     chan error                  // where we get the response
+	Channel         string                      `json:"channel"`
+	Message         string                      `json:"message"`
+	ThreadTimestamp string                      `json:"thread_ts"`       // if non empty, reply to this message
+	ReplyBroadcast  bool                        `json:"reply_broadcast"` // also show the reply in the channel
+	Timestamp       string                      `json:"ts"`              // message to update or delete
+	Key             string                      `json:"key"`             // caller supplied key of the message to update or delete
+	PostAt          time.Time                   `json:"post_at"`         // if in the future, hold the message until then
+	Delay           Duration                    `json:"delay"`           // hold the message for this long
+	Params          slack.PostMessageParameters `json:"params"`
+	op              int                         // what to do with this message
+	owner           string                      // name of the client that sent this message
+	outboxID        uint64                      // ID of this message in the outbox, if any
+	idempotencyKey  string                      // namespaced idempotency key, if any
+	background      bool                        // nobody waits for the outcome, e.g. queued messages
+	file            *messageFile                // file to upload, for opUpload
+	dst             chan error                  // where we get the response
+	postedChannel   string                      // channel ID returned by slack, once posted
+	postedTS        string                      // message timestamp returned by slack, once posted
+}
+
+// postParams returns the parameters to be passed to slack, with the
+// thread related fields merged in
+func (msg *Message) postParams() slack.PostMessageParameters {
+	params := msg.Params
+	if msg.ThreadTimestamp != "" {
+		params.ThreadTimestamp = msg.ThreadTimestamp
+	}
+	if msg.ReplyBroadcast {
+		params.ReplyBroadcast = true
+	}
+	return params
 }
 
 var msgPool = sync.Pool{New: allocMessage}
@@ -228,28 +829,93 @@ func allocMessage() interface{} {
 func releaseMessage(msg *Message) {
 	msg.Channel = ""
 	msg.Message = ""
+	msg.ThreadTimestamp = ""
+	msg.ReplyBroadcast = false
+	msg.Timestamp = ""
+	msg.Key = ""
+	msg.PostAt = time.Time{}
+	msg.Delay = 0
+	msg.op = opPost
+	msg.owner = ""
+	msg.outboxID = 0
+	msg.idempotencyKey = ""
+	msg.background = false
+	msg.file = nil
 	msg.Params = slack.NewPostMessageParameters()
 	msg.dst = nil
+	msg.postedChannel = ""
+	msg.postedTS = ""
 	msgPool.Put(msg)
 }
 
 // extracts a usable slack.OutgoingMessage out of the request.
-// TODO: allow takosan style messages to be parsed, too
+// Form requests may also use takosan style fields (see parseTakosanFields).
+// If a multipart request contains a "file", it is uploaded instead, with
+// the message as its comment
 func (s *Server) extractMessage(r *http.Request) (*Message, error) {
 	var msg *Message
 	switch m := r.Method; strings.ToLower(m) {
 	case "post":
-		switch ct := r.Header.Get("Content-Type"); ct {
+		ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid content type")
+		}
+		switch ct {
 		case "application/json":
 			msg = msgPool.Get().(*Message)
+			// Only the fields the client sends are set by the decoder
+			*msg = Message{Params: slack.NewPostMessageParameters()}
 			if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+				releaseMessage(msg)
 				return nil, errors.Wrap(err, "failed to decode JSON")
 			}
-			return msg, nil
-		case "application/x-www-form-urlencoded":
+		case "application/x-www-form-urlencoded", "multipart/form-data":
 			msg = msgPool.Get().(*Message)
 			msg.Channel = r.FormValue("channel")
 			msg.Message = r.FormValue("message")
+			msg.ThreadTimestamp = r.FormValue("thread_ts")
+			msg.Timestamp = r.FormValue("ts")
+			msg.Key = r.FormValue("key")
+			if v := r.FormValue("post_at"); v != "" {
+				t, err := parseTime(v)
+				if err != nil {
+					releaseMessage(msg)
+					return nil, errors.Wrap(err, "invalid value for post_at")
+				}
+				msg.PostAt = t
+			}
+			if v := r.FormValue("delay"); v != "" {
+				d, err := parseDuration(v)
+				if err != nil {
+					releaseMessage(msg)
+					return nil, errors.Wrap(err, "invalid value for delay")
+				}
+				msg.Delay = Duration(d)
+			}
+			parseTakosanFields(r, msg)
+			if v := r.FormValue("reply_broadcast"); v != "" {
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					releaseMessage(msg)
+					return nil, errors.Wrap(err, "invalid value for reply_broadcast")
+				}
+				msg.ReplyBroadcast = b
+			}
+			if f, fh, err := r.FormFile("file"); err == nil {
+				defer f.Close()
+				buf, err := ioutil.ReadAll(f)
+				if err != nil {
+					releaseMessage(msg)
+					return nil, errors.Wrap(err, "failed to read file")
+				}
+				msg.op = opUpload
+				msg.file = &messageFile{
+					Filename: fh.Filename,
+					Filetype: r.FormValue("filetype"),
+					Title:    fh.Filename,
+					Content:  buf,
+				}
+			}
 		default:
 			return nil, errors.New("unknown content type: " + ct)
 		}
@@ -258,12 +924,7 @@ func (s *Server) extractMessage(r *http.Request) (*Message, error) {
 	}
 
 	if msg.Channel == "" {
-		defer msgPool.Put(msg)
-		return nil, errors.New("channel cannot be empty")
-	}
-
-	if msg.Channel == "" {
-		defer msgPool.Put(msg)
+		releaseMessage(msg)
 		return nil, errors.New("channel cannot be empty")
 	}
 
@@ -1,11 +1,22 @@
 package slackgw
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/nlopes/slack"
 )
 
 func TestPostMessage(t *testing.T) {
@@ -36,4 +47,626 @@ func TestPostMessage(t *testing.T) {
 
 	t.Logf("Waiting...")
 	<-done
-}
\ No newline at end of file
+}
+
+func TestPostMessageAsync(t *testing.T) {
+	s0 := New()
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	release := make(chan struct{})
+	go func() {
+		msg := <-s0.bus
+		<-release
+		msg.postedChannel = "C12345"
+		msg.postedTS = "1461720000.000002"
+		msg.dst <- nil
+	}()
+
+	res, err := http.PostForm(s.URL+"/post?async=1", url.Values{
+		"channel": []string{"#test"},
+		"message": []string{"Hello, World!"},
+	})
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected status 202, got %d", res.StatusCode)
+	}
+
+	var j Job
+	if err := json.NewDecoder(res.Body).Decode(&j); err != nil {
+		t.Fatalf("failed to decode job: %s", err)
+	}
+	if j.Status != JobQueued {
+		t.Errorf("expected job to be queued, got '%s'", j.Status)
+	}
+
+	getJob := func() Job {
+		res, err := http.Get(s.URL + "/jobs/" + j.ID)
+		if err != nil {
+			t.Fatalf("failed to get job: %s", err)
+		}
+		defer res.Body.Close()
+		var j Job
+		if err := json.NewDecoder(res.Body).Decode(&j); err != nil {
+			t.Fatalf("failed to decode job: %s", err)
+		}
+		return j
+	}
+
+	close(release)
+	timeout := time.After(time.Second)
+	for {
+		cur := getJob()
+		if cur.Status == JobSent {
+			if cur.Channel != "C12345" || cur.Timestamp != "1461720000.000002" {
+				t.Errorf("unexpected result: %#v", cur)
+			}
+			break
+		}
+
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for job to complete")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestPostMessageJSON(t *testing.T) {
+	s0 := New()
+	s0.teamURL = "https://example.slack.com/"
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	go func() {
+		msg := <-s0.bus
+		msg.postedChannel = "C12345"
+		msg.postedTS = "1461720000.000002"
+		msg.dst <- nil
+	}()
+
+	v := url.Values{"channel": {"#test"}, "message": {"Hello, World!"}}
+	req, _ := http.NewRequest("POST", s.URL+"/post", strings.NewReader(v.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	defer res.Body.Close()
+
+	var result PostResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode result: %s", err)
+	}
+
+	expected := PostResult{
+		Channel:   "C12345",
+		Timestamp: "1461720000.000002",
+		Permalink: "https://example.slack.com/archives/C12345/p1461720000000002",
+	}
+	if result != expected {
+		t.Errorf("expected %#v, got %#v", expected, result)
+	}
+}
+
+func TestPermalink(t *testing.T) {
+	s := New()
+	s.teamURL = "https://example.slack.com/"
+
+	for _, c := range []struct {
+		thread   string
+		ts       string
+		expected string
+	}{
+		{"", "1461720000.000002", "https://example.slack.com/archives/C12345/p1461720000000002"},
+		{"1461720000.000002", "1461720000.000002", "https://example.slack.com/archives/C12345/p1461720000000002"},
+		{"1461720000.000002", "1461720000.000003", "https://example.slack.com/archives/C12345/p1461720000000003?cid=C12345&thread_ts=1461720000.000002"},
+	} {
+		msg := &Message{ThreadTimestamp: c.thread, postedChannel: "C12345", postedTS: c.ts}
+		if link := s.permalink(msg); link != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, link)
+		}
+	}
+}
+
+type mockSlackClient struct {
+	replies map[string][]slack.Message      // thread_ts -> replies
+	uploads chan slack.FileUploadParameters // if non nil, receives uploaded files
+	names   map[string]string               // channel ID -> name
+	postErr error                           // if non nil, returned by PostMessage
+	lookups int32                           // number of calls to GetConversationInfo. Accessed atomically
+}
+
+func (c *mockSlackClient) NewRTM() *slack.RTM {
+	return nil
+}
+
+func (c *mockSlackClient) AuthTest() (*slack.AuthTestResponse, error) {
+	return &slack.AuthTestResponse{UserID: "U12345", URL: "https://example.slack.com/"}, nil
+}
+
+func (c *mockSlackClient) PostMessage(channel, text string, params slack.PostMessageParameters) (string, string, error) {
+	if c.postErr != nil {
+		return "", "", c.postErr
+	}
+	// Like slack, respond with the ID of channels addressed by name
+	for id, name := range c.names {
+		if channel == "#"+name {
+			channel = id
+		}
+	}
+	return channel, "1461720000.000002", nil
+}
+
+func (c *mockSlackClient) SendMessage(channel string, options ...slack.MsgOption) (string, string, string, error) {
+	return channel, "1461720000.000002", "", nil
+}
+
+func (c *mockSlackClient) GetConversationInfo(channel string, includeLocale bool) (*slack.Channel, error) {
+	atomic.AddInt32(&c.lookups, 1)
+	name, ok := c.names[channel]
+	if !ok {
+		return nil, errors.New("channel_not_found")
+	}
+	info := &slack.Channel{}
+	info.Name = name
+	return info, nil
+}
+
+func (c *mockSlackClient) DeleteMessage(channel, ts string) (string, string, error) {
+	return channel, ts, nil
+}
+
+func (c *mockSlackClient) GetConversationReplies(params *slack.GetConversationRepliesParameters) ([]slack.Message, bool, string, error) {
+	return c.replies[params.Timestamp], false, "", nil
+}
+
+func (c *mockSlackClient) UploadFile(params slack.FileUploadParameters) (*slack.File, error) {
+	if params.Reader != nil {
+		buf, err := ioutil.ReadAll(params.Reader)
+		if err != nil {
+			return nil, err
+		}
+		params.Reader = nil
+		params.Content = string(buf) // makes it easier to check
+	}
+	if c.uploads != nil {
+		c.uploads <- params
+	}
+	return &slack.File{ID: "F12345", Name: params.Filename}, nil
+}
+
+func TestPostThreadedReply(t *testing.T) {
+	var parent slack.Message
+	parent.Timestamp = "1461720000.000001"
+
+	s0 := New()
+	s0.slack = &mockSlackClient{replies: map[string][]slack.Message{parent.Timestamp: {parent}}}
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	received := make(chan slack.PostMessageParameters, 1)
+	go func() {
+		for msg := range s0.bus {
+			received <- msg.postParams()
+			msg.dst <- nil
+		}
+	}()
+
+	tests := []struct {
+		values url.Values
+		status int
+	}{
+		{url.Values{"channel": {"C12345"}, "message": {"progress"}, "thread_ts": {parent.Timestamp}, "reply_broadcast": {"true"}}, http.StatusOK},
+		{url.Values{"channel": {"G12345"}, "message": {"progress"}, "thread_ts": {parent.Timestamp}, "reply_broadcast": {"true"}}, http.StatusOK},
+		{url.Values{"channel": {"D12345"}, "message": {"progress"}, "thread_ts": {parent.Timestamp}, "reply_broadcast": {"true"}}, http.StatusOK},
+		{url.Values{"channel": {"C12345"}, "message": {"progress"}, "thread_ts": {"1461720000.999999"}}, http.StatusBadRequest},
+		{url.Values{"channel": {"C12345"}, "message": {"progress"}, "reply_broadcast": {"true"}}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		res, err := http.PostForm(s.URL+"/post", test.values)
+		if err != nil {
+			t.Errorf("failed to post: %s", err)
+			continue
+		}
+		res.Body.Close()
+
+		if res.StatusCode != test.status {
+			t.Errorf("%v: expected %d, got %d", test.values, test.status, res.StatusCode)
+			continue
+		}
+
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		params := <-received
+		if params.ThreadTimestamp != parent.Timestamp || !params.ReplyBroadcast {
+			t.Errorf("thread parameters were not passed: %#v", params)
+		}
+	}
+}
+
+func TestPostFile(t *testing.T) {
+	s0 := New()
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	uploads := make(chan slack.FileUploadParameters, 1)
+	client := &mockSlackClient{uploads: uploads}
+	go func() {
+		for msg := range s0.bus {
+			msg.dst <- s0.sendMessage(client, msg, nil)
+		}
+	}()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("channel", "#ops")
+	mw.WriteField("message", "nightly build log")
+	mw.WriteField("thread_ts", "1461720000.000001")
+	fw, _ := mw.CreateFormFile("file", "build.log")
+	content := "ok: 42 tests passed\n\x00\xff\xfe" // not valid UTF-8
+	fw.Write([]byte(content))
+	mw.Close()
+
+	res, err := http.Post(s.URL+"/post", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", res.StatusCode)
+	}
+
+	// Uploads cannot be updated, so they cannot have a key
+	body.Reset()
+	mw = multipart.NewWriter(&body)
+	mw.WriteField("channel", "#ops")
+	mw.WriteField("key", "build-log")
+	fw, _ = mw.CreateFormFile("file", "build.log")
+	fw.Write([]byte(content))
+	mw.Close()
+	res, err = http.Post(s.URL+"/post", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status 400 for an upload with a key, got %d", res.StatusCode)
+	}
+
+	select {
+	case params := <-uploads:
+		if params.Filename != "build.log" || params.Content != content || params.InitialComment != "nightly build log" || params.ThreadTimestamp != "1461720000.000001" {
+			t.Errorf("unexpected upload: %#v", params)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for upload")
+	}
+}
+
+func TestUpsertMessage(t *testing.T) {
+	s0 := New()
+	s0.slack = &mockSlackClient{}
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	// Run the real sender against the mock client, but keep track of
+	// the operations performed
+	ops := make(chan int, 8)
+	go func() {
+		for msg := range s0.bus {
+			ops <- msg.op
+			// Give concurrent upserts a chance to look up the key
+			// before the message is recorded
+			time.Sleep(10 * time.Millisecond)
+			msg.dst <- s0.sendMessage(s0.slack, msg, nil)
+		}
+	}()
+
+	post := func(path string, v url.Values) int {
+		res, err := http.PostForm(s.URL+path, v)
+		if err != nil {
+			t.Fatalf("failed to post: %s", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	v := url.Values{"channel": {"#deploy"}, "message": {"deploy 123: running"}, "key": {"deploy-123"}}
+	if st := post("/post", v); st != http.StatusOK {
+		t.Fatalf("expected 200, got %d", st)
+	}
+	if op := <-ops; op != opPost {
+		t.Errorf("first post should post, got op %d", op)
+	}
+
+	v.Set("message", "deploy 123: succeeded")
+	if st := post("/post", v); st != http.StatusOK {
+		t.Fatalf("expected 200, got %d", st)
+	}
+	if op := <-ops; op != opUpdate {
+		t.Errorf("second post should update, got op %d", op)
+	}
+
+	if st := post("/delete", url.Values{"channel": {"#deploy"}, "key": {"deploy-123"}}); st != http.StatusOK {
+		t.Fatalf("expected 200, got %d", st)
+	}
+	if op := <-ops; op != opDelete {
+		t.Errorf("expected delete, got op %d", op)
+	}
+
+	if _, ok := s0.MessageKeys.Get("deploy-123"); ok {
+		t.Errorf("key should be forgotten after delete")
+	}
+
+	if st := post("/update", url.Values{"channel": {"#deploy"}, "message": {"gone"}, "key": {"deploy-123"}}); st != http.StatusNotFound {
+		t.Errorf("updating an unknown key should return 404, got %d", st)
+	}
+
+	// Concurrent upserts of a new key post exactly once
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := http.PostForm(s.URL+"/post", url.Values{"channel": {"#deploy"}, "message": {"deploy 456: running"}, "key": {"deploy-456"}})
+			if err != nil {
+				t.Errorf("failed to post: %s", err)
+				return
+			}
+			res.Body.Close()
+		}()
+	}
+	wg.Wait()
+	var posts int
+	for i := 0; i < 3; i++ {
+		if <-ops == opPost {
+			posts++
+		}
+	}
+	if posts != 1 {
+		t.Errorf("expected concurrent upserts to post once, got %d posts", posts)
+	}
+}
+
+func TestChannelPacing(t *testing.T) {
+	s0 := New()
+	s0.slack = &mockSlackClient{names: map[string]string{"C024BE91L": "ops"}}
+	s0.ChannelInterval = 100 * time.Millisecond
+	defer s0.Close()
+	go s0.watchOutgoingMessages()
+
+	post := func(channel string) time.Time {
+		if err := s0.postMessage(&Message{Channel: channel, Message: "Hello, World!"}); err != nil {
+			t.Fatalf("failed to post: %s", err)
+		}
+		return time.Now()
+	}
+
+	start := time.Now()
+	post("#one")
+	if d := post("#two").Sub(start); d >= s0.ChannelInterval {
+		t.Errorf("messages to different channels should not be paced, took %s", d)
+	}
+	if d := post("#one").Sub(start); d < s0.ChannelInterval {
+		t.Errorf("messages to the same channel should be paced, took %s", d)
+	}
+
+	// Once slack has told us its ID, a channel is paced the same whether
+	// it is addressed by name or by ID
+	start = time.Now()
+	post("#ops")
+	if d := post("C024BE91L").Sub(start); d < s0.ChannelInterval {
+		t.Errorf("messages to the same channel by name and ID should be paced, took %s", d)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	s0 := New()
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	var posted int32
+	go func() {
+		for msg := range s0.bus {
+			n := atomic.AddInt32(&posted, 1)
+			msg.postedChannel = "C12345"
+			msg.postedTS = fmt.Sprintf("1461720000.%06d", n)
+			msg.dst <- nil
+		}
+	}()
+
+	post := func(key string) PostResult {
+		v := url.Values{"channel": {"#alerts"}, "message": {"disk full"}}
+		req, _ := http.NewRequest("POST", s.URL+"/post", strings.NewReader(v.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set(IdempotencyKeyHeader, key)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to post: %s", err)
+		}
+		defer res.Body.Close()
+
+		var result PostResult
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode result: %s", err)
+		}
+		return result
+	}
+
+	first := post("alert-1")
+	if retried := post("alert-1"); retried != first {
+		t.Errorf("retried request should return the original result: %#v != %#v", retried, first)
+	}
+	if other := post("alert-2"); other == first {
+		t.Errorf("different keys should post different messages")
+	}
+	if n := atomic.LoadInt32(&posted); n != 2 {
+		t.Errorf("expected 2 messages to be posted, got %d", n)
+	}
+}
+
+func TestIdempotencyKeyReused(t *testing.T) {
+	s0 := New()
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	var posted int32
+	go func() {
+		for msg := range s0.bus {
+			atomic.AddInt32(&posted, 1)
+			msg.postedChannel = "C12345"
+			msg.postedTS = "1461720000.000001"
+			msg.dst <- nil
+		}
+	}()
+
+	post := func(message string) int {
+		v := url.Values{"channel": {"#alerts"}, "message": {message}}
+		req, _ := http.NewRequest("POST", s.URL+"/post", strings.NewReader(v.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set(IdempotencyKeyHeader, "alert-1")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to post: %s", err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if code := post("disk full"); code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+	if code := post("disk almost full"); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected 422 for a reused key, got %d", code)
+	}
+	if code := post("disk full"); code != http.StatusOK {
+		t.Errorf("expected the retry to succeed, got %d", code)
+	}
+	if n := atomic.LoadInt32(&posted); n != 1 {
+		t.Errorf("expected 1 message to be posted, got %d", n)
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	var c idempotencyCache
+	for _, key := range []string{"a", "b", "c"} {
+		if _, ok := c.claim(key, ""); !ok {
+			t.Fatalf("expected to claim %s", key)
+		}
+	}
+	c.resolve("a", http.StatusOK, nil, nil, -time.Second)
+	c.resolve("b", http.StatusOK, nil, nil, time.Hour)
+	c.resolve("c", http.StatusOK, nil, nil, -time.Minute)
+
+	if _, ok := c.claim("b", ""); ok {
+		t.Errorf("b should still be remembered")
+	}
+	if len(c.entries) != 1 || len(c.expires) != 1 {
+		t.Errorf("expired keys should be forgotten, got %d entries and %d in heap", len(c.entries), len(c.expires))
+	}
+	if _, ok := c.claim("a", ""); !ok {
+		t.Errorf("a should have expired")
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	s0 := New()
+	s0.WebhookChannel = "#deploy"
+	s0.Reload = func() error {
+		s0.Reconfigure(func(s *Server) {
+			s.WebhookChannel = "#releases"
+		})
+		return nil
+	}
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	received := make(chan *Message, 1)
+	go func() {
+		for msg := range s0.bus {
+			c := *msg
+			received <- &c
+			msg.dst <- nil
+		}
+	}()
+
+	post := func() string {
+		res, err := http.Post(s.URL+"/services/x", "application/json", strings.NewReader(`{"text":"Build failed"}`))
+		if err != nil {
+			t.Errorf("failed to post: %s", err)
+			return ""
+		}
+		res.Body.Close()
+		return (<-received).Channel
+	}
+
+	if ch := post(); ch != "#deploy" {
+		t.Errorf("expected '#deploy', got '%s'", ch)
+	}
+
+	// Reload while requests are in flight
+	done := make(chan string)
+	go func() { done <- post() }()
+	s0.reload()
+	<-done
+
+	if ch := post(); ch != "#releases" {
+		t.Errorf("expected '#releases' after reload, got '%s'", ch)
+	}
+}
+
+func TestExtractMessageReset(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	// A multipart upload without a channel is rejected, and must not leave
+	// its file behind for the next request
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("ts", "1461720000.000001")
+	mw.WriteField("key", "deploy")
+	fw, _ := mw.CreateFormFile("file", "secret.txt")
+	fw.Write([]byte("secret"))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/post", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if _, err := s.extractMessage(req); err == nil {
+		t.Errorf("expected an error for a missing channel")
+	}
+
+	// Left over fields must not survive into a JSON request either
+	dirty := msgPool.Get().(*Message)
+	dirty.op = opUpload
+	dirty.file = &messageFile{Filename: "secret.txt"}
+	dirty.Key = "deploy"
+	dirty.Timestamp = "1461720000.000001"
+	dirty.ThreadTimestamp = "1461720000.000001"
+	msgPool.Put(dirty)
+
+	req = httptest.NewRequest("POST", "/post", strings.NewReader(`{"channel":"#test","message":"hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	msg, err := s.extractMessage(req)
+	if err != nil {
+		t.Fatalf("failed to extract message: %s", err)
+	}
+	if msg.op != opPost || msg.file != nil || msg.Key != "" || msg.Timestamp != "" || msg.ThreadTimestamp != "" {
+		t.Errorf("message has left over fields: %#v", msg)
+	}
+	releaseMessage(msg)
+
+	req = httptest.NewRequest("POST", "/post", strings.NewReader(`{"message":"hello"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := s.extractMessage(req); err == nil {
+		t.Errorf("expected an error for a JSON message without a channel")
+	}
+}
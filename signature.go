@@ -0,0 +1,157 @@
+package slackgw
+
+import (
+	"bytes"
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Headers used by signed requests
+const (
+	ClientHeader    = "X-Slackgw-Client"
+	TimestampHeader = "X-Slackgw-Timestamp"
+	SignatureHeader = "X-Slackgw-Signature"
+)
+
+const DefaultSignatureMaxAge = 5 * time.Minute
+
+const signatureVersion = "v1"
+
+// max size of the body of signed requests, which we have to read in full
+// to verify the signature
+const maxSignedBodySize = 32 << 20
+
+// ComputeSignature computes the signature for a request. uri is the
+// path and query of the request (see url.URL.RequestURI). The signature
+// is a hex encoded HMAC-SHA256 of the method, uri, timestamp and body,
+// separated by newlines, prefixed with the signature version.
+func ComputeSignature(secret, method, uri string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.ToUpper(method)))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(uri))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return signatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest adds the headers required for a signed request. body must
+// be the exact content that will be sent as the request body
+func SignRequest(r *http.Request, name, secret string, body []byte, t time.Time) {
+	ts := t.Unix()
+	r.Header.Set(ClientHeader, name)
+	r.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	r.Header.Set(SignatureHeader, ComputeSignature(secret, r.Method, r.URL.RequestURI(), ts, body))
+}
+
+// replayGuard remembers signatures that we have already seen, until
+// they would be rejected as stale anyway
+type replayGuard struct {
+	mutex   sync.Mutex
+	seen    map[string]struct{}
+	expires replayHeap // the signatures in seen, by expiration time
+}
+
+type replayEntry struct {
+	sig     string
+	expires time.Time
+}
+
+// replayHeap is a container/heap of replayEntry, soonest to expire first
+type replayHeap []replayEntry
+
+func (h replayHeap) Len() int            { return len(h) }
+func (h replayHeap) Less(i, j int) bool  { return h[i].expires.Before(h[j].expires) }
+func (h replayHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *replayHeap) Push(x interface{}) { *h = append(*h, x.(replayEntry)) }
+func (h *replayHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+func (g *replayGuard) check(sig string, expires time.Time) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	if g.seen == nil {
+		g.seen = make(map[string]struct{})
+	}
+	for len(g.expires) > 0 && now.After(g.expires[0].expires) {
+		delete(g.seen, heap.Pop(&g.expires).(replayEntry).sig)
+	}
+
+	if _, ok := g.seen[sig]; ok {
+		return false
+	}
+	g.seen[sig] = struct{}{}
+	heap.Push(&g.expires, replayEntry{sig: sig, expires: expires})
+	return true
+}
+
+// verifySignature checks the signature headers in the request, and
+// returns the credential of the client that signed it. The request body
+// is consumed and replaced so that it can be read again afterwards.
+func (s *Server) verifySignature(r *http.Request) (*Credential, error) {
+	name := r.Header.Get(ClientHeader)
+	if name == "" {
+		return nil, errors.New("missing client name")
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid timestamp")
+	}
+
+	maxAge := s.SignatureMaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultSignatureMaxAge
+	}
+	signedAt := time.Unix(ts, 0)
+	if d := time.Since(signedAt); d > maxAge || d < -maxAge {
+		return nil, errors.New("stale timestamp")
+	}
+
+	c, err := s.Credentials.LookupName(name)
+	if err != nil {
+		return nil, err
+	}
+	if c.Secret == "" {
+		return nil, errors.Errorf("credential '%s' has no secret", c.Name)
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxSignedBodySize))
+		r.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read body")
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	sig := r.Header.Get(SignatureHeader)
+	expected := ComputeSignature(c.Secret, r.Method, r.URL.RequestURI(), ts, body)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return nil, errors.New("signature mismatch")
+	}
+
+	if !s.replays.check(sig, signedAt.Add(maxAge)) {
+		return nil, errors.New("signature has already been used")
+	}
+	return c, nil
+}
@@ -0,0 +1,674 @@
+// Code generated by protoc-gen-go.
+// source: slackgw.proto
+// DO NOT EDIT!
+
+/*
+Package slackgwpb is a generated protocol buffer package.
+
+It is generated from these files:
+
+	slackgw.proto
+
+It has these top-level messages:
+
+	PostMessageRequest
+	PostMessageResponse
+	UpdateMessageRequest
+	DeleteMessageRequest
+	GetJobRequest
+	Job
+	StreamEventsRequest
+	Event
+*/
+package slackgwpb
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type PostMessageRequest struct {
+	Channel        string `protobuf:"bytes,1,opt,name=channel" json:"channel,omitempty"`
+	Text           string `protobuf:"bytes,2,opt,name=text" json:"text,omitempty"`
+	ThreadTs       string `protobuf:"bytes,3,opt,name=thread_ts,json=threadTs" json:"thread_ts,omitempty"`
+	ReplyBroadcast bool   `protobuf:"varint,4,opt,name=reply_broadcast,json=replyBroadcast" json:"reply_broadcast,omitempty"`
+	// caller supplied key. If a message with the same key was already
+	// posted, it is updated instead
+	Key string `protobuf:"bytes,5,opt,name=key" json:"key,omitempty"`
+	// if true, return right away with a job ID instead of waiting for slack
+	Async     bool   `protobuf:"varint,6,opt,name=async" json:"async,omitempty"`
+	Username  string `protobuf:"bytes,7,opt,name=username" json:"username,omitempty"`
+	IconEmoji string `protobuf:"bytes,8,opt,name=icon_emoji,json=iconEmoji" json:"icon_emoji,omitempty"`
+	IconUrl   string `protobuf:"bytes,9,opt,name=icon_url,json=iconUrl" json:"icon_url,omitempty"`
+}
+
+func (m *PostMessageRequest) Reset()                    { *m = PostMessageRequest{} }
+func (m *PostMessageRequest) String() string            { return proto.CompactTextString(m) }
+func (*PostMessageRequest) ProtoMessage()               {}
+func (*PostMessageRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *PostMessageRequest) GetChannel() string {
+	if m != nil {
+		return m.Channel
+	}
+	return ""
+}
+
+func (m *PostMessageRequest) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
+
+func (m *PostMessageRequest) GetThreadTs() string {
+	if m != nil {
+		return m.ThreadTs
+	}
+	return ""
+}
+
+func (m *PostMessageRequest) GetReplyBroadcast() bool {
+	if m != nil {
+		return m.ReplyBroadcast
+	}
+	return false
+}
+
+func (m *PostMessageRequest) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *PostMessageRequest) GetAsync() bool {
+	if m != nil {
+		return m.Async
+	}
+	return false
+}
+
+func (m *PostMessageRequest) GetUsername() string {
+	if m != nil {
+		return m.Username
+	}
+	return ""
+}
+
+func (m *PostMessageRequest) GetIconEmoji() string {
+	if m != nil {
+		return m.IconEmoji
+	}
+	return ""
+}
+
+func (m *PostMessageRequest) GetIconUrl() string {
+	if m != nil {
+		return m.IconUrl
+	}
+	return ""
+}
+
+type PostMessageResponse struct {
+	Channel   string `protobuf:"bytes,1,opt,name=channel" json:"channel,omitempty"`
+	Ts        string `protobuf:"bytes,2,opt,name=ts" json:"ts,omitempty"`
+	Permalink string `protobuf:"bytes,3,opt,name=permalink" json:"permalink,omitempty"`
+	// set instead of the above for asynchronous requests
+	JobId string `protobuf:"bytes,4,opt,name=job_id,json=jobId" json:"job_id,omitempty"`
+}
+
+func (m *PostMessageResponse) Reset()                    { *m = PostMessageResponse{} }
+func (m *PostMessageResponse) String() string            { return proto.CompactTextString(m) }
+func (*PostMessageResponse) ProtoMessage()               {}
+func (*PostMessageResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *PostMessageResponse) GetChannel() string {
+	if m != nil {
+		return m.Channel
+	}
+	return ""
+}
+
+func (m *PostMessageResponse) GetTs() string {
+	if m != nil {
+		return m.Ts
+	}
+	return ""
+}
+
+func (m *PostMessageResponse) GetPermalink() string {
+	if m != nil {
+		return m.Permalink
+	}
+	return ""
+}
+
+func (m *PostMessageResponse) GetJobId() string {
+	if m != nil {
+		return m.JobId
+	}
+	return ""
+}
+
+type UpdateMessageRequest struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel" json:"channel,omitempty"`
+	// either ts or key must be specified
+	Ts    string `protobuf:"bytes,2,opt,name=ts" json:"ts,omitempty"`
+	Key   string `protobuf:"bytes,3,opt,name=key" json:"key,omitempty"`
+	Text  string `protobuf:"bytes,4,opt,name=text" json:"text,omitempty"`
+	Async bool   `protobuf:"varint,5,opt,name=async" json:"async,omitempty"`
+}
+
+func (m *UpdateMessageRequest) Reset()                    { *m = UpdateMessageRequest{} }
+func (m *UpdateMessageRequest) String() string            { return proto.CompactTextString(m) }
+func (*UpdateMessageRequest) ProtoMessage()               {}
+func (*UpdateMessageRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *UpdateMessageRequest) GetChannel() string {
+	if m != nil {
+		return m.Channel
+	}
+	return ""
+}
+
+func (m *UpdateMessageRequest) GetTs() string {
+	if m != nil {
+		return m.Ts
+	}
+	return ""
+}
+
+func (m *UpdateMessageRequest) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *UpdateMessageRequest) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
+
+func (m *UpdateMessageRequest) GetAsync() bool {
+	if m != nil {
+		return m.Async
+	}
+	return false
+}
+
+type DeleteMessageRequest struct {
+	Channel string `protobuf:"bytes,1,opt,name=channel" json:"channel,omitempty"`
+	// either ts or key must be specified
+	Ts    string `protobuf:"bytes,2,opt,name=ts" json:"ts,omitempty"`
+	Key   string `protobuf:"bytes,3,opt,name=key" json:"key,omitempty"`
+	Async bool   `protobuf:"varint,4,opt,name=async" json:"async,omitempty"`
+}
+
+func (m *DeleteMessageRequest) Reset()                    { *m = DeleteMessageRequest{} }
+func (m *DeleteMessageRequest) String() string            { return proto.CompactTextString(m) }
+func (*DeleteMessageRequest) ProtoMessage()               {}
+func (*DeleteMessageRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *DeleteMessageRequest) GetChannel() string {
+	if m != nil {
+		return m.Channel
+	}
+	return ""
+}
+
+func (m *DeleteMessageRequest) GetTs() string {
+	if m != nil {
+		return m.Ts
+	}
+	return ""
+}
+
+func (m *DeleteMessageRequest) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *DeleteMessageRequest) GetAsync() bool {
+	if m != nil {
+		return m.Async
+	}
+	return false
+}
+
+type GetJobRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *GetJobRequest) Reset()                    { *m = GetJobRequest{} }
+func (m *GetJobRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetJobRequest) ProtoMessage()               {}
+func (*GetJobRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *GetJobRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+type Job struct {
+	Id        string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Status    string `protobuf:"bytes,2,opt,name=status" json:"status,omitempty"`
+	Error     string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+	Channel   string `protobuf:"bytes,4,opt,name=channel" json:"channel,omitempty"`
+	Ts        string `protobuf:"bytes,5,opt,name=ts" json:"ts,omitempty"`
+	Permalink string `protobuf:"bytes,6,opt,name=permalink" json:"permalink,omitempty"`
+}
+
+func (m *Job) Reset()                    { *m = Job{} }
+func (m *Job) String() string            { return proto.CompactTextString(m) }
+func (*Job) ProtoMessage()               {}
+func (*Job) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *Job) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *Job) GetStatus() string {
+	if m != nil {
+		return m.Status
+	}
+	return ""
+}
+
+func (m *Job) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *Job) GetChannel() string {
+	if m != nil {
+		return m.Channel
+	}
+	return ""
+}
+
+func (m *Job) GetTs() string {
+	if m != nil {
+		return m.Ts
+	}
+	return ""
+}
+
+func (m *Job) GetPermalink() string {
+	if m != nil {
+		return m.Permalink
+	}
+	return ""
+}
+
+type StreamEventsRequest struct {
+	// names of the events to stream, e.g. "MessageEvent". Empty means all
+	Events []string `protobuf:"bytes,1,rep,name=events" json:"events,omitempty"`
+}
+
+func (m *StreamEventsRequest) Reset()                    { *m = StreamEventsRequest{} }
+func (m *StreamEventsRequest) String() string            { return proto.CompactTextString(m) }
+func (*StreamEventsRequest) ProtoMessage()               {}
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+func (m *StreamEventsRequest) GetEvents() []string {
+	if m != nil {
+		return m.Events
+	}
+	return nil
+}
+
+type Event struct {
+	// name of the event, e.g. "MessageEvent"
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// slack's type for the event, e.g. "message"
+	Type string `protobuf:"bytes,2,opt,name=type" json:"type,omitempty"`
+	// the event, encoded as JSON
+	Data []byte `protobuf:"bytes,3,opt,name=data" json:"data,omitempty"`
+}
+
+func (m *Event) Reset()                    { *m = Event{} }
+func (m *Event) String() string            { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()               {}
+func (*Event) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *Event) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Event) GetType() string {
+	if m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+func (m *Event) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*PostMessageRequest)(nil), "slackgw.PostMessageRequest")
+	proto.RegisterType((*PostMessageResponse)(nil), "slackgw.PostMessageResponse")
+	proto.RegisterType((*UpdateMessageRequest)(nil), "slackgw.UpdateMessageRequest")
+	proto.RegisterType((*DeleteMessageRequest)(nil), "slackgw.DeleteMessageRequest")
+	proto.RegisterType((*GetJobRequest)(nil), "slackgw.GetJobRequest")
+	proto.RegisterType((*Job)(nil), "slackgw.Job")
+	proto.RegisterType((*StreamEventsRequest)(nil), "slackgw.StreamEventsRequest")
+	proto.RegisterType((*Event)(nil), "slackgw.Event")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for Slackgw service
+
+type SlackgwClient interface {
+	// PostMessage posts a message, like /post
+	PostMessage(ctx context.Context, in *PostMessageRequest, opts ...grpc.CallOption) (*PostMessageResponse, error)
+	// UpdateMessage updates a message, like /update
+	UpdateMessage(ctx context.Context, in *UpdateMessageRequest, opts ...grpc.CallOption) (*PostMessageResponse, error)
+	// DeleteMessage deletes a message, like /delete
+	DeleteMessage(ctx context.Context, in *DeleteMessageRequest, opts ...grpc.CallOption) (*PostMessageResponse, error)
+	// GetJob returns the status of a message posted asynchronously, like /jobs/{id}
+	GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*Job, error)
+	// StreamEvents streams RTM events as they arrive
+	StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (Slackgw_StreamEventsClient, error)
+}
+
+type slackgwClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewSlackgwClient(cc *grpc.ClientConn) SlackgwClient {
+	return &slackgwClient{cc}
+}
+
+func (c *slackgwClient) PostMessage(ctx context.Context, in *PostMessageRequest, opts ...grpc.CallOption) (*PostMessageResponse, error) {
+	out := new(PostMessageResponse)
+	err := grpc.Invoke(ctx, "/slackgw.Slackgw/PostMessage", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *slackgwClient) UpdateMessage(ctx context.Context, in *UpdateMessageRequest, opts ...grpc.CallOption) (*PostMessageResponse, error) {
+	out := new(PostMessageResponse)
+	err := grpc.Invoke(ctx, "/slackgw.Slackgw/UpdateMessage", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *slackgwClient) DeleteMessage(ctx context.Context, in *DeleteMessageRequest, opts ...grpc.CallOption) (*PostMessageResponse, error) {
+	out := new(PostMessageResponse)
+	err := grpc.Invoke(ctx, "/slackgw.Slackgw/DeleteMessage", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *slackgwClient) GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*Job, error) {
+	out := new(Job)
+	err := grpc.Invoke(ctx, "/slackgw.Slackgw/GetJob", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *slackgwClient) StreamEvents(ctx context.Context, in *StreamEventsRequest, opts ...grpc.CallOption) (Slackgw_StreamEventsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Slackgw_serviceDesc.Streams[0], c.cc, "/slackgw.Slackgw/StreamEvents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &slackgwStreamEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Slackgw_StreamEventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type slackgwStreamEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *slackgwStreamEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Server API for Slackgw service
+
+type SlackgwServer interface {
+	// PostMessage posts a message, like /post
+	PostMessage(context.Context, *PostMessageRequest) (*PostMessageResponse, error)
+	// UpdateMessage updates a message, like /update
+	UpdateMessage(context.Context, *UpdateMessageRequest) (*PostMessageResponse, error)
+	// DeleteMessage deletes a message, like /delete
+	DeleteMessage(context.Context, *DeleteMessageRequest) (*PostMessageResponse, error)
+	// GetJob returns the status of a message posted asynchronously, like /jobs/{id}
+	GetJob(context.Context, *GetJobRequest) (*Job, error)
+	// StreamEvents streams RTM events as they arrive
+	StreamEvents(*StreamEventsRequest, Slackgw_StreamEventsServer) error
+}
+
+func RegisterSlackgwServer(s *grpc.Server, srv SlackgwServer) {
+	s.RegisterService(&_Slackgw_serviceDesc, srv)
+}
+
+func _Slackgw_PostMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PostMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SlackgwServer).PostMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/slackgw.Slackgw/PostMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SlackgwServer).PostMessage(ctx, req.(*PostMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Slackgw_UpdateMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SlackgwServer).UpdateMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/slackgw.Slackgw/UpdateMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SlackgwServer).UpdateMessage(ctx, req.(*UpdateMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Slackgw_DeleteMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SlackgwServer).DeleteMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/slackgw.Slackgw/DeleteMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SlackgwServer).DeleteMessage(ctx, req.(*DeleteMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Slackgw_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SlackgwServer).GetJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/slackgw.Slackgw/GetJob",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SlackgwServer).GetJob(ctx, req.(*GetJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Slackgw_StreamEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SlackgwServer).StreamEvents(m, &slackgwStreamEventsServer{stream})
+}
+
+type Slackgw_StreamEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type slackgwStreamEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *slackgwStreamEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Slackgw_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "slackgw.Slackgw",
+	HandlerType: (*SlackgwServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PostMessage",
+			Handler:    _Slackgw_PostMessage_Handler,
+		},
+		{
+			MethodName: "UpdateMessage",
+			Handler:    _Slackgw_UpdateMessage_Handler,
+		},
+		{
+			MethodName: "DeleteMessage",
+			Handler:    _Slackgw_DeleteMessage_Handler,
+		},
+		{
+			MethodName: "GetJob",
+			Handler:    _Slackgw_GetJob_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamEvents",
+			Handler:       _Slackgw_StreamEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "slackgw.proto",
+}
+
+func init() { proto.RegisterFile("slackgw.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 539 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x54, 0x4d, 0x6f, 0xd3, 0x40,
+	0x10, 0x95, 0x9d, 0xd8, 0x89, 0xa7, 0x49, 0x40, 0xdb, 0x10, 0x2d, 0x69, 0x2b, 0x22, 0x5f, 0xe8,
+	0x85, 0x08, 0xc1, 0x9d, 0x43, 0xa1, 0x02, 0x2a, 0x90, 0x90, 0x4b, 0x2f, 0x5c, 0xa2, 0xb5, 0x3d,
+	0x6a, 0x9c, 0x38, 0x5e, 0xb3, 0xbb, 0x01, 0x2c, 0x71, 0xe6, 0xc2, 0x8f, 0xe4, 0xaf, 0x20, 0xaf,
+	0x3f, 0xe2, 0x04, 0x8b, 0x72, 0xe0, 0xb6, 0xf3, 0x66, 0x3d, 0x6f, 0xf6, 0xcd, 0x1b, 0xc3, 0x50,
+	0xc6, 0x2c, 0x58, 0xdf, 0x7e, 0x9d, 0xa7, 0x82, 0x2b, 0x4e, 0x7a, 0x65, 0xe8, 0xfe, 0x30, 0x81,
+	0x7c, 0xe0, 0x52, 0xbd, 0x47, 0x29, 0xd9, 0x2d, 0x7a, 0xf8, 0x79, 0x8b, 0x52, 0x11, 0x0a, 0xbd,
+	0x60, 0xc9, 0x92, 0x04, 0x63, 0x6a, 0xcc, 0x8c, 0x73, 0xc7, 0xab, 0x42, 0x42, 0xa0, 0xab, 0xf0,
+	0x9b, 0xa2, 0xa6, 0x86, 0xf5, 0x99, 0x9c, 0x80, 0xa3, 0x96, 0x02, 0x59, 0xb8, 0x50, 0x92, 0x76,
+	0x74, 0xa2, 0x5f, 0x00, 0x1f, 0x25, 0x79, 0x0c, 0xf7, 0x04, 0xa6, 0x71, 0xb6, 0xf0, 0x05, 0x67,
+	0x61, 0xc0, 0xa4, 0xa2, 0xdd, 0x99, 0x71, 0xde, 0xf7, 0x46, 0x1a, 0xbe, 0xa8, 0x50, 0x72, 0x1f,
+	0x3a, 0x6b, 0xcc, 0xa8, 0xa5, 0xbf, 0xcf, 0x8f, 0x64, 0x0c, 0x16, 0x93, 0x59, 0x12, 0x50, 0x5b,
+	0x7f, 0x50, 0x04, 0x64, 0x0a, 0xfd, 0xad, 0x44, 0x91, 0xb0, 0x0d, 0xd2, 0x5e, 0x41, 0x56, 0xc5,
+	0xe4, 0x0c, 0x20, 0x0a, 0x78, 0xb2, 0xc0, 0x0d, 0x5f, 0x45, 0xb4, 0xaf, 0xb3, 0x4e, 0x8e, 0x5c,
+	0xe6, 0x00, 0x79, 0x08, 0x7d, 0x9d, 0xde, 0x8a, 0x98, 0x3a, 0xc5, 0xbb, 0xf2, 0xf8, 0x46, 0xc4,
+	0xae, 0x82, 0xe3, 0x3d, 0x1d, 0x64, 0xca, 0x13, 0x89, 0x7f, 0x11, 0x62, 0x04, 0xa6, 0x92, 0xa5,
+	0x0c, 0xa6, 0x92, 0xe4, 0x14, 0x9c, 0x14, 0xc5, 0x86, 0xc5, 0x51, 0xb2, 0x2e, 0x45, 0xd8, 0x01,
+	0xe4, 0x01, 0xd8, 0x2b, 0xee, 0x2f, 0xa2, 0x50, 0x3f, 0xde, 0xf1, 0xac, 0x15, 0xf7, 0xdf, 0x86,
+	0xee, 0x77, 0x18, 0xdf, 0xa4, 0x21, 0x53, 0xf8, 0xcf, 0xfa, 0x1f, 0xd2, 0x96, 0xaa, 0x75, 0x76,
+	0xaa, 0x55, 0x13, 0xea, 0x36, 0x26, 0x54, 0x2b, 0x69, 0x35, 0x94, 0x74, 0x97, 0x30, 0x7e, 0x85,
+	0x31, 0xfe, 0x57, 0xf6, 0x9a, 0xa9, 0xdb, 0x64, 0x7a, 0x04, 0xc3, 0xd7, 0xa8, 0xae, 0xb8, 0x5f,
+	0x51, 0x8c, 0xc0, 0x8c, 0xc2, 0xb2, 0xba, 0x19, 0x85, 0xee, 0x4f, 0x03, 0x3a, 0x57, 0xdc, 0x3f,
+	0xc4, 0xc9, 0x04, 0x6c, 0xa9, 0x98, 0xda, 0x56, 0xa4, 0x65, 0x94, 0xd3, 0xa0, 0x10, 0x5c, 0x94,
+	0xd4, 0x45, 0xd0, 0x6c, 0xbc, 0xdb, 0xd6, 0xb8, 0xd5, 0x3e, 0x2d, 0xfb, 0x60, 0x5a, 0xee, 0x13,
+	0x38, 0xbe, 0x56, 0x02, 0xd9, 0xe6, 0xf2, 0x0b, 0x26, 0x4a, 0x56, 0x4d, 0x4f, 0xc0, 0x46, 0x0d,
+	0x50, 0x63, 0xd6, 0xc9, 0x9b, 0x29, 0x22, 0xf7, 0x25, 0x58, 0xfa, 0x62, 0x2e, 0xbd, 0xb6, 0x65,
+	0xd1, 0xbf, 0x3e, 0xe7, 0x98, 0xca, 0x52, 0xac, 0x17, 0x26, 0x4b, 0x35, 0x16, 0x32, 0xc5, 0x74,
+	0xf3, 0x03, 0x4f, 0x9f, 0x9f, 0xfd, 0x32, 0xa1, 0x77, 0x5d, 0x6c, 0x25, 0x79, 0x03, 0x47, 0x0d,
+	0x33, 0x92, 0x93, 0x79, 0xb5, 0xbd, 0x7f, 0xae, 0xea, 0xf4, 0xb4, 0x3d, 0x59, 0xfa, 0xf7, 0x1d,
+	0x0c, 0xf7, 0x0c, 0x46, 0xce, 0xea, 0xeb, 0x6d, 0xc6, 0xbb, 0xbb, 0xda, 0x9e, 0x61, 0x1a, 0xd5,
+	0xda, 0x8c, 0x74, 0x47, 0xb5, 0x39, 0xd8, 0x85, 0x29, 0xc8, 0xa4, 0xbe, 0xb7, 0xe7, 0x92, 0xe9,
+	0xa0, 0xc6, 0xf3, 0x5b, 0x2f, 0x60, 0xd0, 0x9c, 0x0a, 0xd9, 0x55, 0x6f, 0x19, 0xd6, 0x74, 0x54,
+	0x67, 0x35, 0xfe, 0xd4, 0xb8, 0x38, 0xfa, 0xe4, 0x94, 0x50, 0xea, 0xfb, 0xb6, 0xfe, 0x11, 0x3e,
+	0xff, 0x3d, 0x00, 0xda, 0x41, 0xe9, 0xe2, 0x19, 0x05, 0x00, 0x00,
+}
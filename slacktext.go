@@ -0,0 +1,28 @@
+package slackgw
+
+import "strings"
+
+// slack escapes these in message text
+var slackUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&")
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeSlack escapes text that comes from outside (commit messages, log
+// lines and such), so that it is shown as is: otherwise anybody who can
+// get text posted could ping the whole channel with <!channel>, or
+// disguise links
+func escapeSlack(text string) string {
+	return slackEscaper.Replace(text)
+}
+
+// characters that would end a link early
+var slackURLEscaper = strings.NewReplacer("<", "%3C", ">", "%3E", "|", "%7C")
+
+// slackLink formats a link to url, with text escaped. If url is empty,
+// just the text is returned
+func slackLink(url, text string) string {
+	if url == "" {
+		return escapeSlack(text)
+	}
+	return "<" + slackURLEscaper.Replace(url) + "|" + escapeSlack(text) + ">"
+}
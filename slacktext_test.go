@@ -0,0 +1,24 @@
+package slackgw
+
+import "testing"
+
+func TestEscapeSlack(t *testing.T) {
+	if s := escapeSlack("<!channel> a & b <http://example.com|c>"); s != "&lt;!channel&gt; a &amp; b &lt;http://example.com|c&gt;" {
+		t.Errorf("unexpected escaped text '%s'", s)
+	}
+	if s := slackUnescaper.Replace(escapeSlack("a &amp; <b>")); s != "a &amp; <b>" {
+		t.Errorf("expected escaping to be reversible, got '%s'", s)
+	}
+
+	for _, test := range []struct {
+		url, text, expected string
+	}{
+		{"", "<!here>", "&lt;!here&gt;"},
+		{"https://example.com/?a=1&b=2", "x > y", "<https://example.com/?a=1&b=2|x &gt; y>"},
+		{"https://example.com/|<!channel>", "x", "<https://example.com/%7C%3C!channel%3E|x>"},
+	} {
+		if s := slackLink(test.url, test.text); s != test.expected {
+			t.Errorf("expected '%s', got '%s'", test.expected, s)
+		}
+	}
+}
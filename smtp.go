@@ -0,0 +1,405 @@
+package slackgw
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Defaults for the SMTP server
+const (
+	DefaultSMTPMaxSize = 10 * 1024 * 1024
+	DefaultSMTPDomain  = "slackgw.local"
+)
+
+const (
+	smtpMaxRecipients = 100
+	smtpBodyPreview   = 1000 // max number of characters of the body included in the message
+	smtpTimeout       = 5 * time.Minute
+)
+
+func (s *Server) smtpDomain() string {
+	if s.SMTPDomain != "" {
+		return s.SMTPDomain
+	}
+	return DefaultSMTPDomain
+}
+
+func (s *Server) smtpMaxSize() int64 {
+	if s.SMTPMaxSize > 0 {
+		return s.SMTPMaxSize
+	}
+	return DefaultSMTPMaxSize
+}
+
+// smtpChannel returns the channel for the recipient address, or an empty
+// string if we do not accept mail for it. Only the local parts listed in
+// SMTPChannels are accepted, since there is no authentication
+func (s *Server) smtpChannel(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if a, err := mail.ParseAddress(addr); err == nil {
+		addr = a.Address
+	}
+
+	i := strings.LastIndexByte(addr, '@')
+	if i <= 0 || !strings.EqualFold(addr[i+1:], s.smtpDomain()) {
+		return ""
+	}
+	local := strings.ToLower(addr[:i])
+
+	s.config.RLock()
+	defer s.config.RUnlock()
+	return s.SMTPChannels[local]
+}
+
+// ReadSMTPChannels reads a JSON object mapping local parts of addresses
+// to channels from filename. See Server.SMTPChannels
+func ReadSMTPChannels(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open SMTP channels")
+	}
+	defer f.Close()
+
+	var m map[string]string
+	if err := json.NewDecoder(f).Decode(&m); err != nil {
+		return nil, errors.Wrap(err, "failed to decode SMTP channels")
+	}
+
+	channels := make(map[string]string, len(m))
+	for local, channel := range m {
+		channels[strings.ToLower(local)] = channel
+	}
+	return channels, nil
+}
+
+// smtpPath extracts the address out of "FROM:<addr> PARAMS" or "TO:<addr>"
+func smtpPath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end == -1 {
+		return "", false
+	}
+	return arg[1:end], true
+}
+
+// StartSMTP starts a minimal SMTP server on the given address. Mail sent
+// to <local part>@SMTPDomain is posted to the corresponding channel (see
+// SMTPChannels), and the original message is attached as a file
+func (s *Server) StartSMTP(listen string) error {
+	done := s.done
+	if done == nil {
+		return errors.New("server is not connected or is shutting down")
+	}
+
+	s.config.RLock()
+	n := len(s.SMTPChannels)
+	s.config.RUnlock()
+	if n == 0 {
+		return errors.New("no SMTP channels configured")
+	}
+
+	if pdebug.Enabled {
+		pdebug.Printf("Listening for SMTP on %s", listen)
+	}
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+	go func() {
+		<-done
+		l.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if pdebug.Enabled {
+					pdebug.Printf("smtp: stopped accepting: %s", err)
+				}
+				return
+			}
+			go s.serveSMTP(conn)
+		}
+	}()
+	return nil
+}
+
+type smtpSession struct {
+	from       string
+	recipients []string // channels
+}
+
+func (s *Server) serveSMTP(conn net.Conn) {
+	defer conn.Close()
+
+	hostname, _ := os.Hostname()
+	tp := textproto.NewConn(conn)
+	reply := func(format string, args ...interface{}) bool {
+		conn.SetWriteDeadline(time.Now().Add(smtpTimeout))
+		return tp.PrintfLine(format, args...) == nil
+	}
+
+	if !reply("220 %s slackgw ESMTP", hostname) {
+		return
+	}
+
+	var sess smtpSession
+	for {
+		conn.SetReadDeadline(time.Now().Add(smtpTimeout))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		var ok bool
+		switch strings.ToUpper(cmd) {
+		case "HELO":
+			ok = reply("250 %s", hostname)
+		case "EHLO":
+			ok = reply("250-%s", hostname) && reply("250-SIZE %d", s.smtpMaxSize()) && reply("250 8BITMIME")
+		case "MAIL":
+			from, valid := smtpPath(arg, "FROM:")
+			if !valid {
+				ok = reply("501 Syntax: MAIL FROM:<address>")
+				break
+			}
+			sess = smtpSession{from: from}
+			ok = reply("250 OK")
+		case "RCPT":
+			to, valid := smtpPath(arg, "TO:")
+			switch {
+			case !valid:
+				ok = reply("501 Syntax: RCPT TO:<address>")
+			case len(sess.recipients) >= smtpMaxRecipients:
+				ok = reply("452 Too many recipients")
+			default:
+				channel := s.smtpChannel(to)
+				if channel == "" {
+					ok = reply("550 No such mailbox: %s", to)
+					break
+				}
+				sess.recipients = append(sess.recipients, channel)
+				ok = reply("250 OK")
+			}
+		case "DATA":
+			if len(sess.recipients) == 0 {
+				ok = reply("503 Need RCPT first")
+				break
+			}
+			if !reply("354 End data with <CR><LF>.<CR><LF>") {
+				return
+			}
+			conn.SetReadDeadline(time.Now().Add(smtpTimeout))
+			dr := tp.DotReader()
+			data, err := ioutil.ReadAll(io.LimitReader(dr, s.smtpMaxSize()+1))
+			if err != nil {
+				return
+			}
+			if int64(len(data)) > s.smtpMaxSize() {
+				io.Copy(ioutil.Discard, dr)
+				sess = smtpSession{}
+				ok = reply("552 Message exceeds maximum size")
+				break
+			}
+			if err := s.handleMail(sess.recipients, data); err != nil {
+				if pdebug.Enabled {
+					pdebug.Printf("smtp: failed to handle mail from %s: %s", sess.from, err)
+				}
+				sess = smtpSession{}
+				ok = reply("554 %s", err)
+				break
+			}
+			sess = smtpSession{}
+			ok = reply("250 OK: queued")
+		case "RSET":
+			sess = smtpSession{}
+			ok = reply("250 OK")
+		case "NOOP":
+			ok = reply("250 OK")
+		case "VRFY":
+			ok = reply("252 Cannot verify user")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			ok = reply("502 Command not implemented")
+		}
+		if !ok {
+			return
+		}
+	}
+}
+
+// mailText extracts the plain text body of a message
+func mailText(header textproto.MIMEHeader, body io.Reader) (string, error) {
+	ct := header.Get("Content-Type")
+	if ct == "" {
+		ct = "text/plain"
+	}
+	mediatype, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		mediatype = "text/plain"
+	}
+
+	switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	if strings.HasPrefix(mediatype, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				if err == io.EOF {
+					return "", nil
+				}
+				return "", err
+			}
+			// multipart.Reader already decodes quoted-printable parts
+			text, err := mailText(part.Header, part)
+			if err != nil || text != "" {
+				return text, err
+			}
+		}
+	}
+
+	if mediatype != "text/plain" {
+		return "", nil
+	}
+	// Charsets we do not know are passed through as is: that still
+	// beats rejecting the alert
+	if r, err := charsetReader(params["charset"], body); err == nil {
+		body = r
+	}
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// charsetReader decodes text in charset (e.g. iso-2022-jp) to UTF-8
+func charsetReader(charset string, r io.Reader) (io.Reader, error) {
+	if charset == "" {
+		return r, nil
+	}
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, errors.Errorf("unsupported charset '%s'", charset)
+	}
+	return enc.NewDecoder().Reader(r), nil
+}
+
+func trimMailBody(body string) string {
+	body = strings.TrimSpace(strings.Replace(body, "\r\n", "\n", -1))
+	if r := []rune(body); len(r) > smtpBodyPreview {
+		body = string(r[:smtpBodyPreview]) + "…"
+	}
+	return body
+}
+
+// handleMail posts the message in data to each of the channels. The
+// message is queued, and the original message is uploaded in a thread
+// under it once it has been posted
+func (s *Server) handleMail(channels []string, data []byte) error {
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "failed to parse message")
+	}
+
+	dec := &mime.WordDecoder{CharsetReader: charsetReader}
+	subject, err := dec.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		subject = m.Header.Get("Subject")
+	}
+	if subject == "" {
+		subject = "(no subject)"
+	}
+	from, err := dec.DecodeHeader(m.Header.Get("From"))
+	if err != nil {
+		from = m.Header.Get("From")
+	}
+
+	body, err := mailText(textproto.MIMEHeader(m.Header), m.Body)
+	if err != nil {
+		return errors.Wrap(err, "failed to read body")
+	}
+
+	// Anybody who can send mail could otherwise ping the channel
+	text := fmt.Sprintf("*%s*", escapeSlack(subject))
+	if from != "" {
+		text += " (from " + escapeSlack(from) + ")"
+	}
+	if body = trimMailBody(body); body != "" {
+		text += "\n```" + escapeSlack(body) + "```"
+	}
+
+	file := &messageFile{
+		Filename: "message.eml",
+		Filetype: "email",
+		Title:    subject,
+		Content:  data,
+	}
+
+	for _, channel := range channels {
+		msg := msgPool.Get().(*Message)
+		msg.Channel = channel
+		msg.Message = text
+		go s.postMail(msg, file)
+	}
+	return nil
+}
+
+// postMail posts msg, and then uploads file in a thread under it
+func (s *Server) postMail(msg *Message, file *messageFile) {
+	defer releaseMessage(msg)
+	if err := s.postMessage(msg); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("smtp: failed to post to '%s': %s", msg.Channel, err)
+		}
+		return
+	}
+
+	upload := msgPool.Get().(*Message)
+	defer releaseMessage(upload)
+	upload.op = opUpload
+	upload.Channel = msg.postedChannel
+	upload.ThreadTimestamp = msg.postedTS
+	upload.file = file
+	upload.background = true // keep transient failures in the outbox
+	if err := s.postMessage(upload); err != nil && pdebug.Enabled {
+		pdebug.Printf("smtp: failed to upload message to '%s': %s", msg.Channel, err)
+	}
+}
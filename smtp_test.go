@@ -0,0 +1,116 @@
+package slackgw
+
+import (
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+)
+
+func TestSMTP(t *testing.T) {
+	s0 := New()
+	defer s0.Close()
+	s0.SMTPChannels = map[string]string{"ops": "#ops"}
+
+	uploads := make(chan slack.FileUploadParameters, 1)
+	client := &mockSlackClient{uploads: uploads}
+	received := make(chan *Message, 2)
+	go func() {
+		for msg := range s0.bus {
+			c := *msg
+			received <- &c
+			msg.dst <- s0.sendMessage(client, msg, nil)
+		}
+	}()
+
+	// Find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	if err := s0.StartSMTP(addr); err != nil {
+		t.Fatalf("failed to start SMTP server: %s", err)
+	}
+
+	body := "From: ups@example.com\r\n" +
+		"To: ops@slackgw.local\r\n" +
+		"Subject: =?UTF-8?Q?UPS_on_battery?=\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Input power failure detected at =\r\n" +
+		"rack 12. <!channel>\r\n"
+
+	if err := smtp.SendMail(addr, nil, "ups@example.com", []string{"nobody@slackgw.local"}, []byte(body)); err == nil {
+		t.Errorf("expected unknown recipients to be rejected")
+	}
+	if err := smtp.SendMail(addr, nil, "ups@example.com", []string{"ops@slackgw.local"}, []byte(body)); err != nil {
+		t.Fatalf("failed to send mail: %s", err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.Channel != "#ops" || !strings.Contains(msg.Message, "*UPS on battery*") || !strings.Contains(msg.Message, "Input power failure detected at rack 12. &lt;!channel&gt;") {
+			t.Errorf("unexpected message: %#v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+
+	select {
+	case params := <-uploads:
+		if params.ThreadTimestamp != "1461720000.000002" || params.Filename != "message.eml" || !strings.Contains(params.Content, "Subject: =?UTF-8?Q?UPS_on_battery?=") {
+			t.Errorf("unexpected upload: %#v", params)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for upload")
+	}
+
+	// Neither UTF-8 nor a charset the standard library knows
+	body = "From: ups@example.com\r\n" +
+		"To: ops@slackgw.local\r\n" +
+		"Subject: =?windows-1252?Q?Temp=E9rature?=\r\n" +
+		"Content-Type: text/plain; charset=iso-8859-15\r\n" +
+		"\r\n" +
+		"Temp\xe9rature: 42\xb0C, co\xfbt 10\xa4\r\n"
+	if err := smtp.SendMail(addr, nil, "ups@example.com", []string{"ops@slackgw.local"}, []byte(body)); err != nil {
+		t.Fatalf("failed to send mail: %s", err)
+	}
+
+	<-received // the upload of the first message
+	select {
+	case msg := <-received:
+		if !strings.Contains(msg.Message, "*Température*") || !strings.Contains(msg.Message, "Température: 42°C, coût 10€") {
+			t.Errorf("charset was not decoded: %s", msg.Message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for message")
+	}
+
+	select {
+	case params := <-uploads:
+		if params.Content != strings.Replace(body, "\r\n", "\n", -1) {
+			t.Errorf("original message should be uploaded as is, got %q", params.Content)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for upload")
+	}
+}
+
+func TestSMTPRequiresChannels(t *testing.T) {
+	s := New()
+	defer s.Close()
+
+	if err := s.StartSMTP("127.0.0.1:0"); err == nil {
+		t.Errorf("expected an error without SMTP channels")
+	}
+	if ch := s.smtpChannel("ops@slackgw.local"); ch != "" {
+		t.Errorf("expected mail to be rejected, got channel '%s'", ch)
+	}
+}
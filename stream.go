@@ -0,0 +1,341 @@
+package slackgw
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+// EventStreamEndpoint is where RTM events are streamed from. Credentials
+// list it in their endpoints to stream events, over HTTP or gRPC
+const EventStreamEndpoint = "/events/stream"
+
+// DefaultStreamBuffer is the number of events buffered for each
+// streaming client, if Server.StreamBuffer is 0
+const DefaultStreamBuffer = 64
+
+// What to do with events for streaming clients whose buffer is full
+const (
+	DropNewest     = "newest"     // drop the new event
+	DropOldest     = "oldest"     // drop the oldest buffered event to make room for the new one
+	DropDisconnect = "disconnect" // disconnect the client
+)
+
+// how often idle SSE connections are pinged
+const streamHeartbeat = 30 * time.Second
+
+// StreamEvent is what /events/stream sends for each RTM event
+type StreamEvent struct {
+	Name    string      `json:"name"` // e.g. "MessageEvent", see EventNameToMask
+	Type    string      `json:"type"` // the RTM event type, e.g. "message"
+	Data    interface{} `json:"data"`
+	Dropped uint64      `json:"dropped,omitempty"` // number of matching events that were dropped before this one
+}
+
+func (s *Server) streamBuffer() int {
+	if s.StreamBuffer > 0 {
+		return s.StreamBuffer
+	}
+	return DefaultStreamBuffer
+}
+
+func (s *Server) streamDropPolicy() string {
+	if s.StreamDropPolicy != "" {
+		return s.StreamDropPolicy
+	}
+	return DropNewest
+}
+
+// eventFilter selects the events sent to a streaming client
+type eventFilter struct {
+	names    map[string]struct{}       // if non nil, only these events
+	channels map[string]struct{}       // if non nil, only events in these channels
+	userID   string                    // if non empty, only messages addressed to this user
+	allowed  func(channel string) bool // if non nil, only events in channels for which this returns true. See allows
+}
+
+func newEventFilter(names, channels []string, userID string) (*eventFilter, error) {
+	f := &eventFilter{userID: userID}
+	if len(names) > 0 {
+		f.names = make(map[string]struct{})
+		for _, name := range names {
+			if EventNameToMask(name) == -1 {
+				return nil, errors.Errorf("unknown event '%s'", name)
+			}
+			f.names[name] = struct{}{}
+		}
+	}
+	if len(channels) > 0 {
+		f.channels = make(map[string]struct{})
+		for _, channel := range channels {
+			f.channels[channel] = struct{}{}
+		}
+	}
+	return f, nil
+}
+
+func (f *eventFilter) match(ev slack.RTMEvent) bool {
+	if f.names != nil {
+		if _, ok := f.names[eventName(ev)]; !ok {
+			return false
+		}
+	}
+	if f.channels != nil {
+		if _, ok := f.channels[eventChannel(ev)]; !ok {
+			return false
+		}
+	}
+	if f.allowed != nil {
+		// Events that are not specific to a channel are not covered by
+		// the client's scope either
+		if eventChannel(ev) == "" {
+			return false
+		}
+	}
+	if f.userID != "" {
+		m, ok := ev.Data.(*slack.MessageEvent)
+		if !ok {
+			return false
+		}
+		if _, ok := AddressedTo(m.Text, f.userID); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// allows checks if ev is in a channel the client may see. Unlike match,
+// which is called by the hub for every subscriber, it may have to ask
+// slack, so it is called by each subscriber before sending the event
+func (f *eventFilter) allows(ev slack.RTMEvent) bool {
+	if f == nil || f.allowed == nil {
+		return true
+	}
+	return f.allowed(eventChannel(ev))
+}
+
+// eventChannel returns the ID of the channel the event happened in, or an
+// empty string if it is not specific to a channel
+func eventChannel(ev slack.RTMEvent) string {
+	v := reflect.Indirect(reflect.ValueOf(ev.Data))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if f := v.FieldByName("Channel"); f.Kind() == reflect.String {
+		return f.String()
+	}
+	// Reactions, pins and stars refer to the channel of their item
+	if item := reflect.Indirect(v.FieldByName("Item")); item.Kind() == reflect.Struct {
+		if f := item.FieldByName("Channel"); f.Kind() == reflect.String {
+			return f.String()
+		}
+	}
+	return ""
+}
+
+// channelScope returns a function that checks if events in a channel
+// may be streamed to a client authenticated with c, or nil if c is not
+// restricted to certain channels. Channel names are cached by
+// allowsChannel, and shared by all clients
+func (s *Server) channelScope(c *Credential) func(string) bool {
+	if c == nil || len(c.Channels) == 0 {
+		return nil
+	}
+	return func(ch string) bool {
+		return s.allowsChannel(c, ch)
+	}
+}
+
+// checkOrigin rejects WebSocket handshakes from web pages on other sites.
+// Browsers cannot send the auth header over WebSocket, so it is mostly
+// a concern when authentication is off. Clients that are not browsers
+// usually do not send an Origin at all
+func checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return errors.Wrap(err, "invalid origin")
+	}
+	if !strings.EqualFold(u.Host, r.Host) {
+		return errors.Errorf("origin '%s' is not allowed", origin)
+	}
+	return nil
+}
+
+// newStreamEvent wraps ev for sending to clients. ok is false if ev
+// cannot be encoded
+func newStreamEvent(ev slack.RTMEvent, dropped uint64) (*StreamEvent, bool) {
+	se := &StreamEvent{Name: eventName(ev), Type: ev.Type, Data: ev.Data, Dropped: dropped}
+	if _, err := json.Marshal(se); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to encode %s: %s", se.Name, err)
+		}
+		return nil, false
+	}
+	return se, true
+}
+
+// httpEventStream streams RTM events to the client, as server-sent events
+// or over a WebSocket. The events may be limited by the following query
+// parameters:
+//
+//	event=MessageEvent   only events with this name (may be repeated)
+//	channel=C024BE91L    only events in this channel (may be repeated)
+//	self=true            only messages addressed to this bot
+func (s *Server) httpEventStream(w http.ResponseWriter, r *http.Request) {
+	if pdebug.Enabled {
+		pdebug.Printf("http: event stream request...")
+		defer pdebug.Printf("done with event stream request")
+	}
+
+	cred, err := s.authenticate(r)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to authenticate: %s", err)
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if strings.ToLower(r.Method) != "get" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	var userID string
+	if v := q.Get("self"); v != "" {
+		self, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid value for self", http.StatusBadRequest)
+			return
+		}
+		if self {
+			userID = s.slackuser
+		}
+	}
+	f, err := newEventFilter(q["event"], q["channel"], userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if cred != nil {
+		for _, ch := range q["channel"] {
+			if !s.allowsChannel(cred, ch) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		f.allowed = s.channelScope(cred)
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		ws := websocket.Server{
+			Handshake: func(_ *websocket.Config, r *http.Request) error { return checkOrigin(r) },
+			Handler:   func(ws *websocket.Conn) { s.streamWebSocket(ws, f) },
+		}
+		ws.ServeHTTP(w, r)
+		return
+	}
+	s.streamSSE(w, r, f)
+}
+
+func (s *Server) streamSSE(w http.ResponseWriter, r *http.Request, f *eventFilter) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := s.events.subscribe(s.streamBuffer(), s.streamDropPolicy(), f)
+	defer s.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.gone:
+			fmt.Fprintf(w, "event: error\ndata: too slow, disconnected\n\n")
+			flusher.Flush()
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev := <-sub.ch:
+			if !f.allows(ev) {
+				continue
+			}
+			se, ok := newStreamEvent(ev, sub.takeDropped())
+			if !ok {
+				continue
+			}
+			buf, _ := json.Marshal(se)
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", se.Name, buf); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) streamWebSocket(ws *websocket.Conn, f *eventFilter) {
+	defer ws.Close()
+
+	sub := s.events.subscribe(s.streamBuffer(), s.streamDropPolicy(), f)
+	defer s.events.unsubscribe(sub)
+
+	// We do not expect anything from the client, but need to read to
+	// notice when it goes away
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, ws)
+		close(closed)
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case <-sub.gone:
+			if pdebug.Enabled {
+				pdebug.Printf("disconnecting slow WebSocket client")
+			}
+			return
+		case ev := <-sub.ch:
+			if !f.allows(ev) {
+				continue
+			}
+			se, ok := newStreamEvent(ev, sub.takeDropped())
+			if !ok {
+				continue
+			}
+			if err := websocket.JSON.Send(ws, se); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package slackgw
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"golang.org/x/net/websocket"
+)
+
+func newTestMessageEvent(channel, text string) slack.RTMEvent {
+	m := &slack.MessageEvent{}
+	m.Channel = channel
+	m.Text = text
+	return slack.RTMEvent{Type: "message", Data: m}
+}
+
+// publishUntil keeps publishing events until done is closed, as clients
+// subscribe asynchronously
+func publishUntil(h *eventHub, done chan struct{}, events ...slack.RTMEvent) {
+	for {
+		for _, ev := range events {
+			h.publish(ev)
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	s0 := New()
+	defer s0.Close()
+	s0.slackuser = "U12345"
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/events/stream?event=Bogus")
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown event, got %d", res.StatusCode)
+	}
+
+	res, err = http.Get(s.URL + "/events/stream?event=MessageEvent&channel=C1&self=true")
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type '%s'", ct)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go publishUntil(&s0.events, done,
+		slack.RTMEvent{Type: "hello", Data: &slack.HelloEvent{}},
+		newTestMessageEvent("C2", "<@U12345> wrong channel"),
+		newTestMessageEvent("C1", "not for us"),
+		newTestMessageEvent("C1", "<@U12345|slackgw>: deploy"),
+	)
+
+	received := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				received <- strings.TrimPrefix(line, "data: ")
+				return
+			}
+		}
+	}()
+
+	select {
+	case data := <-received:
+		var se struct {
+			Name string
+			Data struct{ Channel, Text string }
+		}
+		if err := json.Unmarshal([]byte(data), &se); err != nil {
+			t.Fatalf("failed to decode event: %s", err)
+		}
+		if se.Name != "MessageEvent" || se.Data.Channel != "C1" || se.Data.Text != "<@U12345|slackgw>: deploy" {
+			t.Errorf("unexpected event: %s", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+}
+
+func TestEventStreamWebSocket(t *testing.T) {
+	s0 := New()
+	defer s0.Close()
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/events/stream?event=MessageEvent", "", s.URL)
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	defer ws.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go publishUntil(&s0.events, done,
+		slack.RTMEvent{Type: "hello", Data: &slack.HelloEvent{}},
+		newTestMessageEvent("C1", "hello"),
+	)
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	var se StreamEvent
+	if err := websocket.JSON.Receive(ws, &se); err != nil {
+		t.Fatalf("failed to receive event: %s", err)
+	}
+	if se.Name != "MessageEvent" || se.Type != "message" {
+		t.Errorf("unexpected event: %#v", se)
+	}
+}
+
+func TestEventStreamScope(t *testing.T) {
+	s0 := New()
+	defer s0.Close()
+	s0.AuthHeader = "X-Slackgw-Auth"
+	s0.Credentials = newTestCredentialStore(t)
+	s0.slack = &mockSlackClient{names: map[string]string{"C024BE91L": "deploy", "C024BE91M": "general"}}
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	get := func(query string) *http.Response {
+		req, _ := http.NewRequest("GET", s.URL+"/events/stream"+query, nil)
+		req.Header.Set("X-Slackgw-Auth", "deploy-key")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to connect: %s", err)
+		}
+		return res
+	}
+
+	res := get("?channel=C024BE91M")
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for a channel outside of the key's scope, got %d", res.StatusCode)
+	}
+
+	// Without a channel filter, only events in #deploy are streamed
+	res = get("")
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go publishUntil(&s0.events, done,
+		slack.RTMEvent{Type: "hello", Data: &slack.HelloEvent{}},
+		newTestMessageEvent("C024BE91M", "general"),
+		newTestMessageEvent("C024BE91L", "deploy"),
+	)
+
+	received := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+				received <- strings.TrimPrefix(line, "data: ")
+				return
+			}
+		}
+	}()
+
+	select {
+	case data := <-received:
+		if !strings.Contains(data, `"text":"deploy"`) {
+			t.Errorf("unexpected event: %s", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for event")
+	}
+}
+
+func TestEventStreamOrigin(t *testing.T) {
+	s0 := New()
+	defer s0.Close()
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/events/stream"
+	if ws, err := websocket.Dial(url, "", "http://evil.example.com"); err == nil {
+		ws.Close()
+		t.Errorf("expected WebSocket from another origin to be rejected")
+	}
+	ws, err := websocket.Dial(url, "", s.URL)
+	if err != nil {
+		t.Fatalf("expected WebSocket from the same origin to be accepted: %s", err)
+	}
+	ws.Close()
+}
+
+func TestEventHubDropPolicy(t *testing.T) {
+	events := []slack.RTMEvent{
+		newTestMessageEvent("C1", "1"),
+		newTestMessageEvent("C1", "2"),
+		newTestMessageEvent("C1", "3"),
+	}
+
+	for _, test := range []struct {
+		policy string
+		text   string // text of the buffered event
+	}{
+		{DropNewest, "1"},
+		{DropOldest, "3"},
+	} {
+		var h eventHub
+		sub := h.subscribe(1, test.policy, nil)
+		for _, ev := range events {
+			h.publish(ev)
+		}
+		if text := (<-sub.ch).Data.(*slack.MessageEvent).Text; text != test.text {
+			t.Errorf("%s: expected '%s' to be buffered, got '%s'", test.policy, test.text, text)
+		}
+		if n := sub.takeDropped(); n != 2 {
+			t.Errorf("%s: expected 2 dropped events, got %d", test.policy, n)
+		}
+	}
+
+	var h eventHub
+	sub := h.subscribe(1, DropDisconnect, nil)
+	for _, ev := range events {
+		h.publish(ev)
+	}
+	select {
+	case <-sub.gone:
+	default:
+		t.Errorf("slow subscriber was not disconnected")
+	}
+}
+
+func TestEventHubScopeOutsideLock(t *testing.T) {
+	// Scope checks may call slack, so the hub must not wait for them
+	release := make(chan struct{})
+	defer close(release)
+	f := &eventFilter{allowed: func(string) bool {
+		<-release
+		return true
+	}}
+
+	var h eventHub
+	sub := h.subscribe(1, DropNewest, f)
+	published := make(chan struct{})
+	go func() {
+		h.publish(newTestMessageEvent("C1", "hello"))
+		h.publish(slack.RTMEvent{Type: "hello", Data: &slack.HelloEvent{}})
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatalf("publish is blocked by the scope check")
+	}
+
+	// Events without a channel are still dropped by the hub
+	if n := len(sub.ch); n != 1 {
+		t.Errorf("expected 1 event for the subscriber, got %d", n)
+	}
+}
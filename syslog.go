@@ -0,0 +1,563 @@
+package slackgw
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+)
+
+// Defaults for rate limiting syslog messages
+const (
+	DefaultSyslogInterval = time.Minute
+	DefaultSyslogBurst    = 10
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "panic": 0, "alert": 1, "crit": 2, "err": 3, "error": 3,
+	"warning": 4, "warn": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+var syslogSeverityNames = []string{"EMERG", "ALERT", "CRIT", "ERR", "WARNING", "NOTICE", "INFO", "DEBUG"}
+
+// SyslogRule routes syslog messages to a channel. All non empty
+// conditions must match. Rules are evaluated in order, and the first
+// matching rule wins
+type SyslogRule struct {
+	Facilities []string `json:"facilities"` // e.g. "kern", "local0"
+	Severity   string   `json:"severity"`   // matches this severity and anything more severe, e.g. "crit"
+	Hostname   string   `json:"hostname"`   // regular expression
+	Message    string   `json:"message"`    // regular expression
+	Channel    string   `json:"channel"`
+}
+
+type syslogRule struct {
+	facilities map[int]struct{}
+	severity   int
+	hostname   *regexp.Regexp
+	message    *regexp.Regexp
+	channel    string
+}
+
+func compileSyslogRule(r SyslogRule) (*syslogRule, error) {
+	if r.Channel == "" {
+		return nil, errors.New("syslog rule does not specify a channel")
+	}
+
+	c := &syslogRule{severity: 7, channel: r.Channel}
+	if len(r.Facilities) > 0 {
+		c.facilities = make(map[int]struct{})
+		for _, name := range r.Facilities {
+			f, ok := syslogFacilities[strings.ToLower(name)]
+			if !ok {
+				return nil, errors.Errorf("unknown syslog facility '%s'", name)
+			}
+			c.facilities[f] = struct{}{}
+		}
+	}
+	if r.Severity != "" {
+		sev, ok := syslogSeverities[strings.ToLower(r.Severity)]
+		if !ok {
+			return nil, errors.Errorf("unknown syslog severity '%s'", r.Severity)
+		}
+		c.severity = sev
+	}
+
+	var err error
+	if r.Hostname != "" {
+		if c.hostname, err = regexp.Compile(r.Hostname); err != nil {
+			return nil, errors.Wrap(err, "invalid hostname pattern")
+		}
+	}
+	if r.Message != "" {
+		if c.message, err = regexp.Compile(r.Message); err != nil {
+			return nil, errors.Wrap(err, "invalid message pattern")
+		}
+	}
+	return c, nil
+}
+
+func (r *syslogRule) match(m *syslogMessage) bool {
+	if r.facilities != nil {
+		if _, ok := r.facilities[m.Facility]; !ok {
+			return false
+		}
+	}
+	if m.Severity > r.severity {
+		return false
+	}
+	if r.hostname != nil && !r.hostname.MatchString(m.Hostname) {
+		return false
+	}
+	if r.message != nil && !r.message.MatchString(m.Message) {
+		return false
+	}
+	return true
+}
+
+// ReadSyslogRules reads a JSON list of SyslogRules from filename
+func ReadSyslogRules(filename string) ([]SyslogRule, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open syslog rules")
+	}
+	defer f.Close()
+
+	var rules []SyslogRule
+	if err := json.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, errors.Wrap(err, "failed to decode syslog rules")
+	}
+	for i, r := range rules {
+		if _, err := compileSyslogRule(r); err != nil {
+			return nil, errors.Wrapf(err, "invalid syslog rule #%d", i+1)
+		}
+	}
+	return rules, nil
+}
+
+type syslogMessage struct {
+	Facility  int
+	Severity  int
+	Timestamp time.Time
+	Hostname  string
+	AppName   string
+	Message   string
+}
+
+// parseSyslog parses RFC 5424 and RFC 3164 messages. Plenty of devices
+// send something that only vaguely resembles RFC 3164, so anything after
+// the priority that cannot be parsed becomes part of the message
+func parseSyslog(line string) (*syslogMessage, error) {
+	line = strings.TrimRight(line, "\r\n\x00")
+	if !strings.HasPrefix(line, "<") {
+		return nil, errors.New("missing priority")
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return nil, errors.New("invalid priority")
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri > 191 {
+		return nil, errors.New("invalid priority")
+	}
+
+	m := &syslogMessage{Facility: pri / 8, Severity: pri % 8}
+	rest := line[end+1:]
+	if strings.HasPrefix(rest, "1 ") {
+		parseSyslog5424(m, rest[2:])
+	} else {
+		parseSyslog3164(m, rest)
+	}
+	return m, nil
+}
+
+// parseSyslog5424 parses TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func parseSyslog5424(m *syslogMessage, rest string) {
+	fields := make([]string, 0, 5)
+	for len(fields) < 5 {
+		i := strings.IndexByte(rest, ' ')
+		if i == -1 {
+			fields = append(fields, rest)
+			rest = ""
+			break
+		}
+		fields = append(fields, rest[:i])
+		rest = rest[i+1:]
+	}
+	for len(fields) < 5 {
+		fields = append(fields, "-")
+	}
+
+	nilvalue := func(s string) string {
+		if s == "-" {
+			return ""
+		}
+		return s
+	}
+	if t, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+		m.Timestamp = t
+	}
+	m.Hostname = nilvalue(fields[1])
+	m.AppName = nilvalue(fields[2])
+
+	// Skip structured data: either "-", or one or more [...] elements,
+	// in which ']' may be escaped
+	if strings.HasPrefix(rest, "-") {
+		rest = rest[1:]
+	} else {
+		for strings.HasPrefix(rest, "[") {
+			i := 1
+			for ; i < len(rest); i++ {
+				if rest[i] == '\\' {
+					i++
+					continue
+				}
+				if rest[i] == ']' {
+					break
+				}
+			}
+			if i >= len(rest) {
+				rest = ""
+				break
+			}
+			rest = rest[i+1:]
+		}
+	}
+	m.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\xef\xbb\xbf")
+}
+
+// parseSyslog3164 parses Mmm dd hh:mm:ss HOSTNAME TAG: MSG
+func parseSyslog3164(m *syslogMessage, rest string) {
+	if len(rest) >= 16 && rest[15] == ' ' {
+		if t, err := time.Parse(time.Stamp, rest[:15]); err == nil {
+			now := time.Now()
+			m.Timestamp = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+			rest = rest[16:]
+
+			if i := strings.IndexByte(rest, ' '); i > 0 && !strings.ContainsAny(rest[:i], ":[") {
+				m.Hostname = rest[:i]
+				rest = rest[i+1:]
+			}
+		}
+	}
+
+	// TAG is alphanumeric, optionally followed by [pid], and then ':'
+	if i := strings.IndexByte(rest, ':'); i > 0 && i <= 48 && !strings.ContainsAny(rest[:i], " \t") {
+		tag := rest[:i]
+		if j := strings.IndexByte(tag, '['); j > 0 {
+			tag = tag[:j]
+		}
+		m.AppName = tag
+		rest = strings.TrimPrefix(rest[i+1:], " ")
+	}
+	m.Message = rest
+}
+
+// syslogChannelState tracks what has been sent to a channel in the
+// current interval
+type syslogChannelState struct {
+	sent       int
+	suppressed int
+	repeats    map[string]*syslogRepeat
+}
+
+type syslogRepeat struct {
+	msg   *syslogMessage
+	count int
+}
+
+// syslogLimiter collapses repeated lines, and limits the number of lines
+// sent to each channel per interval
+type syslogLimiter struct {
+	mutex    sync.Mutex
+	channels map[string]*syslogChannelState
+	started  bool
+}
+
+func (s *Server) syslogInterval() time.Duration {
+	if s.SyslogInterval > 0 {
+		return s.SyslogInterval
+	}
+	return DefaultSyslogInterval
+}
+
+func (s *Server) syslogBurst() int {
+	if s.SyslogBurst > 0 {
+		return s.SyslogBurst
+	}
+	return DefaultSyslogBurst
+}
+
+func syslogKey(m *syslogMessage) string {
+	return m.Hostname + "\x00" + m.AppName + "\x00" + m.Message
+}
+
+// formatSyslog formats m for slack. Anybody who can reach the listener
+// can send lines, so the fields are escaped
+func formatSyslog(m *syslogMessage) string {
+	var buf []byte
+	buf = append(buf, '*')
+	buf = append(buf, syslogSeverityNames[m.Severity]...)
+	buf = append(buf, '*')
+	if m.Hostname != "" {
+		buf = append(buf, " `"...)
+		buf = append(buf, escapeSlack(m.Hostname)...)
+		buf = append(buf, '`')
+	}
+	if m.AppName != "" {
+		buf = append(buf, ' ')
+		buf = append(buf, escapeSlack(m.AppName)...)
+		buf = append(buf, ':')
+	}
+	buf = append(buf, ' ')
+	buf = append(buf, escapeSlack(m.Message)...)
+	return string(buf)
+}
+
+// allow decides if m may be sent to channel right away. Lines that were
+// already sent in this interval, or that exceed the burst, are counted
+// and reported when the interval ends
+func (l *syslogLimiter) allow(channel string, m *syslogMessage, burst int) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.channels == nil {
+		l.channels = make(map[string]*syslogChannelState)
+	}
+	st, ok := l.channels[channel]
+	if !ok {
+		st = &syslogChannelState{repeats: make(map[string]*syslogRepeat)}
+		l.channels[channel] = st
+	}
+
+	key := syslogKey(m)
+	if r, ok := st.repeats[key]; ok {
+		r.count++
+		return false
+	}
+	if st.sent >= burst {
+		st.suppressed++
+		return false
+	}
+	st.sent++
+	st.repeats[key] = &syslogRepeat{msg: m}
+	return true
+}
+
+// flush returns the summary messages for the interval that just ended,
+// and starts a new one
+func (l *syslogLimiter) flush() map[string][]string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	summaries := make(map[string][]string)
+	for channel, st := range l.channels {
+		for _, r := range st.repeats {
+			if r.count > 0 {
+				summaries[channel] = append(summaries[channel], fmt.Sprintf("%s (repeated %d more times)", formatSyslog(r.msg), r.count))
+			}
+		}
+		if st.suppressed > 0 {
+			summaries[channel] = append(summaries[channel], fmt.Sprintf("%d more syslog lines were suppressed", st.suppressed))
+		}
+	}
+	l.channels = nil
+	return summaries
+}
+
+// sendSyslog posts text to channel without blocking the listener
+func (s *Server) sendSyslog(channel, text string) {
+	msg := msgPool.Get().(*Message)
+	msg.Channel = channel
+	msg.Message = text
+	msg.background = true // keep transient failures in the outbox
+	go func() {
+		defer releaseMessage(msg)
+		if err := s.postMessage(msg); err != nil && pdebug.Enabled {
+			pdebug.Printf("failed to post syslog message to '%s': %s", channel, err)
+		}
+	}()
+}
+
+// handleSyslog routes a single syslog line
+func (s *Server) handleSyslog(rules []*syslogRule, line string, from net.Addr) {
+	m, err := parseSyslog(line)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("syslog: ignoring message from %s: %s", from, err)
+		}
+		return
+	}
+	if m.Hostname == "" && from != nil {
+		if host, _, err := net.SplitHostPort(from.String()); err == nil {
+			m.Hostname = host
+		}
+	}
+
+	for _, r := range rules {
+		if !r.match(m) {
+			continue
+		}
+		if s.syslog.allow(r.channel, m, s.syslogBurst()) {
+			s.sendSyslog(r.channel, formatSyslog(m))
+		}
+		return
+	}
+}
+
+// flushSyslog periodically reports collapsed and suppressed lines
+func (s *Server) flushSyslog(done chan struct{}) {
+	t := time.NewTicker(s.syslogInterval())
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			for channel, lines := range s.syslog.flush() {
+				s.sendSyslog(channel, strings.Join(lines, "\n"))
+			}
+		}
+	}
+}
+
+// StartSyslog starts a syslog server on the given address. proto is
+// either "udp" or "tcp". Messages are routed according to SyslogRules,
+// and those that do not match any rule are dropped
+func (s *Server) StartSyslog(proto, listen string) error {
+	done := s.done
+	if done == nil {
+		return errors.New("server is not connected or is shutting down")
+	}
+
+	rules := make([]*syslogRule, len(s.SyslogRules))
+	for i, r := range s.SyslogRules {
+		c, err := compileSyslogRule(r)
+		if err != nil {
+			return errors.Wrapf(err, "invalid syslog rule #%d", i+1)
+		}
+		rules[i] = c
+	}
+
+	if pdebug.Enabled {
+		pdebug.Printf("Listening for syslog on %s:%s", proto, listen)
+	}
+
+	switch proto {
+	case "udp", "udp4", "udp6":
+		conn, err := net.ListenPacket(proto, listen)
+		if err != nil {
+			return errors.Wrap(err, "failed to listen")
+		}
+		go func() {
+			<-done
+			conn.Close()
+		}()
+		go s.serveSyslogUDP(conn, rules)
+	case "tcp", "tcp4", "tcp6":
+		l, err := net.Listen(proto, listen)
+		if err != nil {
+			return errors.Wrap(err, "failed to listen")
+		}
+		go func() {
+			<-done
+			l.Close()
+		}()
+		go s.serveSyslogTCP(l, rules)
+	default:
+		return errors.Errorf("unsupported protocol '%s'", proto)
+	}
+
+	s.syslog.mutex.Lock()
+	if !s.syslog.started {
+		s.syslog.started = true
+		go s.flushSyslog(done)
+	}
+	s.syslog.mutex.Unlock()
+	return nil
+}
+
+func (s *Server) serveSyslogUDP(conn net.PacketConn, rules []*syslogRule) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if pdebug.Enabled {
+				pdebug.Printf("syslog: stopped reading: %s", err)
+			}
+			return
+		}
+		s.handleSyslog(rules, string(buf[:n]), from)
+	}
+}
+
+func (s *Server) serveSyslogTCP(l net.Listener, rules []*syslogRule) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if pdebug.Enabled {
+				pdebug.Printf("syslog: stopped accepting: %s", err)
+			}
+			return
+		}
+		go s.serveSyslogConn(conn, rules)
+	}
+}
+
+// maxSyslogMessage is the largest message accepted over TCP
+const maxSyslogMessage = 1024 * 1024
+
+// serveSyslogConn reads messages from a TCP connection. Both octet
+// counting ("LEN MSG") and newline delimited framing are accepted
+// (RFC 6587)
+func (s *Server) serveSyslogConn(conn net.Conn, rules []*syslogRule) {
+	defer conn.Close()
+
+	sc := bufio.NewScanner(conn)
+	// Leave room for the length in front of the largest message
+	sc.Buffer(make([]byte, 4096), maxSyslogMessage+16)
+	sc.Split(splitSyslog)
+	for sc.Scan() {
+		s.handleSyslog(rules, sc.Text(), conn.RemoteAddr())
+	}
+	if err := sc.Err(); err != nil && pdebug.Enabled {
+		pdebug.Printf("syslog: stopped reading from %s: %s", conn.RemoteAddr(), err)
+	}
+}
+
+// splitSyslog is a bufio.SplitFunc for the framings accepted by
+// serveSyslogConn. Messages longer than maxSyslogMessage are rejected
+// by the Scanner's buffer limit, or by the length check
+func splitSyslog(data []byte, atEOF bool) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if data[0] >= '0' && data[0] <= '9' {
+		sp := bytes.IndexByte(data, ' ')
+		if sp == -1 {
+			if atEOF || len(data) > len(strconv.Itoa(maxSyslogMessage)) {
+				return 0, nil, errors.New("invalid message length")
+			}
+			return 0, nil, nil
+		}
+		n, err := strconv.Atoi(string(data[:sp]))
+		if err != nil || n <= 0 || n > maxSyslogMessage {
+			return 0, nil, errors.New("invalid message length")
+		}
+		if end := sp + 1 + n; len(data) >= end {
+			return end, data[sp+1 : end], nil
+		}
+		if atEOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexByte(data, '\n'); i != -1 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,164 @@
+package slackgw
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslog(t *testing.T) {
+	for _, tc := range []struct {
+		line     string
+		facility int
+		severity int
+		hostname string
+		appname  string
+		message  string
+	}{
+		{
+			line:     "<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8",
+			facility: 4, severity: 2, hostname: "mymachine", appname: "su",
+			message: "'su root' failed for lonvick on /dev/pts/8",
+		},
+		{
+			line:     "<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventID=\"1011\"] An application event",
+			facility: 20, severity: 5, hostname: "mymachine.example.com", appname: "evntslog",
+			message: "An application event",
+		},
+		{
+			line:     "<187>%LINK-3-UPDOWN: Interface GigabitEthernet0/1, changed state to down\n",
+			facility: 23, severity: 3, appname: "%LINK-3-UPDOWN",
+			message: "Interface GigabitEthernet0/1, changed state to down",
+		},
+	} {
+		m, err := parseSyslog(tc.line)
+		if err != nil {
+			t.Errorf("failed to parse '%s': %s", tc.line, err)
+			continue
+		}
+		if m.Facility != tc.facility || m.Severity != tc.severity || m.Hostname != tc.hostname || m.AppName != tc.appname || m.Message != tc.message {
+			t.Errorf("unexpected result for '%s': %#v", tc.line, m)
+		}
+	}
+
+	if _, err := parseSyslog("no priority here"); err == nil {
+		t.Errorf("expected lines without a priority to be rejected")
+	}
+}
+
+func TestFormatSyslog(t *testing.T) {
+	m := &syslogMessage{Severity: 2, Hostname: "router1", AppName: "sshd", Message: "<!channel> login from <evil> & co"}
+	if s := formatSyslog(m); s != "*CRIT* `router1` sshd: &lt;!channel&gt; login from &lt;evil&gt; &amp; co" {
+		t.Errorf("unexpected message '%s'", s)
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	s0 := New()
+	defer s0.Close()
+	s0.SyslogRules = []SyslogRule{
+		{Severity: "crit", Channel: "#netops"},
+	}
+	s0.SyslogBurst = 2
+	s0.SyslogInterval = time.Hour
+
+	received := make(chan *Message, 10)
+	go func() {
+		for msg := range s0.bus {
+			c := *msg
+			received <- &c
+			msg.dst <- nil
+		}
+	}()
+
+	// Find a free port
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	if err := s0.StartSyslog("udp", addr); err != nil {
+		t.Fatalf("failed to start syslog server: %s", err)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer conn.Close()
+
+	for _, line := range []string{
+		"<26>Oct 11 22:14:15 router1 kernel: link down",
+		"<26>Oct 11 22:14:16 router1 kernel: link down", // repeated
+		"<30>Oct 11 22:14:17 router1 kernel: link up",   // info, does not match
+		"<25>Oct 11 22:14:18 router2 kernel: fan failed",
+		"<25>Oct 11 22:14:19 router3 kernel: psu failed", // over the burst
+	} {
+		conn.Write([]byte(line))
+	}
+
+	var texts []string
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			if msg.Channel != "#netops" {
+				t.Errorf("expected #netops, got %s", msg.Channel)
+			}
+			texts = append(texts, msg.Message)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for syslog messages")
+		}
+	}
+	joined := strings.Join(texts, "\n")
+	if !strings.Contains(joined, "`router1` kernel: link down") || !strings.Contains(joined, "fan failed") {
+		t.Errorf("unexpected messages: %q", texts)
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("unexpected message: %q", msg.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	summary := strings.Join(s0.syslog.flush()["#netops"], "\n")
+	if !strings.Contains(summary, "repeated 1 more times") || !strings.Contains(summary, "1 more syslog lines were suppressed") {
+		t.Errorf("unexpected summary: %q", summary)
+	}
+}
+
+func TestSyslogFraming(t *testing.T) {
+	scan := func(input string) ([]string, error) {
+		sc := bufio.NewScanner(strings.NewReader(input))
+		sc.Buffer(make([]byte, 16), 64)
+		sc.Split(splitSyslog)
+		var lines []string
+		for sc.Scan() {
+			lines = append(lines, sc.Text())
+		}
+		return lines, sc.Err()
+	}
+
+	lines, err := scan("10 <26>a b: c<26>d e: f\n<26>g h: i")
+	if err != nil {
+		t.Fatalf("failed to scan: %s", err)
+	}
+	expected := []string{"<26>a b: c", "<26>d e: f\n", "<26>g h: i"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected %q, got %q", expected, lines)
+	}
+
+	for _, input := range []string{
+		strings.Repeat("x", 100),      // no newline within the limit
+		"12345678901 <26>a b: c",      // length way over the limit
+		"0 <26>a b: c",                // invalid length
+		"20 <26>a b: c",               // truncated
+		strings.Repeat("1", 20) + "x", // length without a space
+	} {
+		if _, err := scan(input); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
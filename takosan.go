@@ -0,0 +1,70 @@
+package slackgw
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+// takosan (https://github.com/kentaro/takosan) attachment fields, and
+// the corresponding slack.Attachment fields
+var takosanAttachmentFields = []struct {
+	name string
+	set  func(*slack.Attachment, string)
+}{
+	{"color", func(a *slack.Attachment, v string) { a.Color = v }},
+	{"pretext", func(a *slack.Attachment, v string) { a.Pretext = v }},
+	{"fallback", func(a *slack.Attachment, v string) { a.Fallback = v }},
+	{"author_name", func(a *slack.Attachment, v string) { a.AuthorName = v }},
+	{"author_link", func(a *slack.Attachment, v string) { a.AuthorLink = v }},
+	{"author_icon", func(a *slack.Attachment, v string) { a.AuthorIcon = v }},
+	{"title", func(a *slack.Attachment, v string) { a.Title = v }},
+	{"title_link", func(a *slack.Attachment, v string) { a.TitleLink = v }},
+	{"text", func(a *slack.Attachment, v string) { a.Text = v }},
+	{"image_url", func(a *slack.Attachment, v string) { a.ImageURL = v }},
+	{"thumb_url", func(a *slack.Attachment, v string) { a.ThumbURL = v }},
+	{"footer", func(a *slack.Attachment, v string) { a.Footer = v }},
+	{"footer_icon", func(a *slack.Attachment, v string) { a.FooterIcon = v }},
+}
+
+// parseTakosanFields reads the form fields used by takosan, so that
+// scripts written against takosan work without changes.
+//
+// "name" and "icon" set the bot's name and icon (either an emoji like
+// ":ghost:" or a URL). If any attachment field is given, an attachment
+// is built out of them. Like takosan, the message then becomes the
+// attachment's text unless "text" is specified
+func parseTakosanFields(r *http.Request, msg *Message) {
+	if v := r.FormValue("name"); v != "" {
+		msg.Params.Username = v
+	}
+	if v := r.FormValue("icon"); v != "" {
+		if strings.HasPrefix(v, ":") && strings.HasSuffix(v, ":") {
+			msg.Params.IconEmoji = v
+		} else {
+			msg.Params.IconURL = v
+		}
+	}
+
+	var a slack.Attachment
+	var found bool
+	for _, f := range takosanAttachmentFields {
+		if v := r.FormValue(f.name); v != "" {
+			f.set(&a, v)
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+
+	if a.Text == "" {
+		a.Text = msg.Message
+		msg.Message = ""
+	}
+	if a.Fallback == "" {
+		a.Fallback = a.Text
+	}
+	msg.Params.Attachments = append(msg.Params.Attachments, a)
+}
@@ -0,0 +1,55 @@
+package slackgw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestTakosanFields(t *testing.T) {
+	s0 := New()
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	received := make(chan *Message, 1)
+	go func() {
+		for msg := range s0.bus {
+			c := *msg
+			received <- &c
+			msg.dst <- nil
+		}
+	}()
+
+	res, err := http.PostForm(s.URL+"/notice", url.Values{
+		"channel":    {"#ops"},
+		"message":    {"deploy finished"},
+		"name":       {"deploybot"},
+		"icon":       {":rocket:"},
+		"color":      {"good"},
+		"title":      {"app v1.2.3"},
+		"title_link": {"https://example.com/releases/1.2.3"},
+	})
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+
+	msg := <-received
+	if msg.Params.Username != "deploybot" || msg.Params.IconEmoji != ":rocket:" {
+		t.Errorf("name and icon were not mapped: %#v", msg.Params)
+	}
+	if len(msg.Params.Attachments) != 1 {
+		t.Fatalf("expected an attachment, got %#v", msg.Params.Attachments)
+	}
+	a := msg.Params.Attachments[0]
+	if a.Color != "good" || a.Title != "app v1.2.3" || a.TitleLink != "https://example.com/releases/1.2.3" {
+		t.Errorf("attachment fields were not mapped: %#v", a)
+	}
+	if a.Text != "deploy finished" || msg.Message != "" {
+		t.Errorf("message should become the attachment text: %#v", msg)
+	}
+}
@@ -0,0 +1,161 @@
+package slackgw
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/lestrrat/go-pdebug"
+	"github.com/nlopes/slack"
+	"github.com/pkg/errors"
+)
+
+// WebhookPayload is the payload accepted by slack's incoming webhooks.
+// See https://api.slack.com/incoming-webhooks
+type WebhookPayload struct {
+	Text            string             `json:"text"`
+	Channel         string             `json:"channel"`
+	Username        string             `json:"username"`
+	IconEmoji       string             `json:"icon_emoji"`
+	IconURL         string             `json:"icon_url"`
+	Attachments     []slack.Attachment `json:"attachments"`
+	ThreadTimestamp string             `json:"thread_ts"`
+	Markdown        *bool              `json:"mrkdwn"`
+	UnfurlLinks     *bool              `json:"unfurl_links"`
+	UnfurlMedia     *bool              `json:"unfurl_media"`
+	LinkNames       interface{}        `json:"link_names"` // slack accepts both 1 and true
+}
+
+// extractWebhookPayload reads the payload either from a JSON body, or
+// from the "payload" field of a form, as slack does
+func extractWebhookPayload(r *http.Request) (*WebhookPayload, error) {
+	if strings.ToLower(r.Method) != "post" {
+		return nil, errors.New("unsupported method: " + r.Method)
+	}
+
+	ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid content type")
+	}
+
+	var payload WebhookPayload
+	switch ct {
+	case "application/json":
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			return nil, errors.Wrap(err, "failed to decode JSON")
+		}
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := json.Unmarshal([]byte(r.FormValue("payload")), &payload); err != nil {
+			return nil, errors.Wrap(err, "failed to decode payload")
+		}
+	default:
+		return nil, errors.New("unknown content type: " + ct)
+	}
+	return &payload, nil
+}
+
+// toMessage maps the payload onto a Message. defaultChannel is used
+// when the payload does not specify one
+func (p *WebhookPayload) toMessage(defaultChannel string) *Message {
+	msg := msgPool.Get().(*Message)
+	msg.Channel = p.Channel
+	if msg.Channel == "" {
+		msg.Channel = defaultChannel
+	}
+	msg.Message = p.Text
+	msg.ThreadTimestamp = p.ThreadTimestamp
+
+	params := &msg.Params
+	params.Username = p.Username
+	params.IconEmoji = p.IconEmoji
+	params.IconURL = p.IconURL
+	params.Attachments = p.Attachments
+	if p.Markdown != nil {
+		params.Markdown = *p.Markdown
+	}
+	if p.UnfurlLinks != nil {
+		params.UnfurlLinks = *p.UnfurlLinks
+	}
+	if p.UnfurlMedia != nil {
+		params.UnfurlMedia = *p.UnfurlMedia
+	}
+	switch v := p.LinkNames.(type) {
+	case bool:
+		if v {
+			params.LinkNames = 1
+		}
+	case float64:
+		params.LinkNames = int(v)
+	}
+	return msg
+}
+
+// httpWebhook accepts payloads in the format of slack's incoming webhooks,
+// so that tools that can only talk to webhooks can use slackgw. Such tools
+// usually cannot send custom headers, so the key may be given as the
+// last part of the URL instead: /services/<key>
+func (s *Server) httpWebhook(w http.ResponseWriter, r *http.Request) {
+	if pdebug.Enabled {
+		pdebug.Printf("http: webhook request...")
+		defer pdebug.Printf("done with webhook request")
+	}
+
+	key := r.Header.Get(s.AuthHeader)
+	if key == "" {
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		key = path[strings.LastIndex(path, "/")+1:]
+	}
+
+	cred, err := s.authenticateKey(r, key, "/services")
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to authenticate: %s", err)
+		}
+		http.Error(w, "invalid_token", http.StatusForbidden)
+		return
+	}
+
+	payload, err := extractWebhookPayload(r)
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to extract payload: %s", err)
+		}
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+
+	if payload.Text == "" && len(payload.Attachments) == 0 {
+		http.Error(w, "no_text", http.StatusBadRequest)
+		return
+	}
+
+	s.config.RLock()
+	msg := payload.toMessage(s.WebhookChannel)
+	s.config.RUnlock()
+	defer releaseMessage(msg)
+
+	if msg.Channel == "" {
+		http.Error(w, "channel_not_found", http.StatusNotFound)
+		return
+	}
+
+	if cred != nil {
+		if !s.allowsChannel(cred, msg.Channel) {
+			http.Error(w, "action_prohibited", http.StatusForbidden)
+			return
+		}
+		msg.owner = cred.Name
+	}
+
+	if err := s.postMessage(msg); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to post message: %s", err)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
@@ -0,0 +1,56 @@
+package slackgw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	s0 := New()
+	s0.AuthHeader = "X-Slackgw-Auth"
+	s0.Credentials = newTestCredentialStore(t)
+	s0.WebhookChannel = "#deploy"
+	s := httptest.NewServer(s0)
+	defer s.Close()
+
+	received := make(chan *Message, 1)
+	go func() {
+		for msg := range s0.bus {
+			c := *msg
+			received <- &c
+			msg.dst <- nil
+		}
+	}()
+
+	payload := `{"text":"Build failed","username":"ci","icon_emoji":":x:","attachments":[{"color":"danger","text":"see logs"}]}`
+	res, err := http.Post(s.URL+"/services/deploy-key", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.StatusCode)
+	}
+
+	msg := <-received
+	if msg.Channel != "#deploy" || msg.Message != "Build failed" {
+		t.Errorf("unexpected message: %#v", msg)
+	}
+	if msg.Params.Username != "ci" || msg.Params.IconEmoji != ":x:" {
+		t.Errorf("username and icon were not mapped: %#v", msg.Params)
+	}
+	if len(msg.Params.Attachments) != 1 || msg.Params.Attachments[0].Color != "danger" {
+		t.Errorf("attachments were not mapped: %#v", msg.Params.Attachments)
+	}
+
+	res, err = http.Post(s.URL+"/services/wrong-key", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("failed to post: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for an unknown key, got %d", res.StatusCode)
+	}
+}